@@ -0,0 +1,405 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type APIConfig struct {
+	Listen       string `yaml:"listen"`       // address of the HTTP API, e.g. 127.0.0.1:8080, disabled if empty
+	StreamBuffer int    `yaml:"streamBuffer"` // matches kept for stream clients resuming with Last-Event-ID, 1000 by default
+
+	APIKeys []string `yaml:"apiKeys"` // bearer tokens with access to all tenants and to managing watchers
+}
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+// API serves the match history and the name inventory as JSON, new matches
+// as server-sent events, and the dashboard. Watchers can be managed with an
+// API key.
+type API struct {
+	store    Store
+	stream   *Stream
+	status   *Status
+	reloader *Reloader // validates and applies watcher changes
+	mux      *http.ServeMux
+
+	watchersMutex sync.Mutex // serializes validating and saving watchers
+}
+
+func NewAPI(store Store, stream *Stream, status *Status, reloader *Reloader) *API {
+	a := &API{store: store, stream: stream, status: status, reloader: reloader, mux: http.NewServeMux()}
+	a.mux.HandleFunc("GET /api/v1/matches", a.authenticated(a.withStore(a.listMatches)))
+	a.mux.HandleFunc("GET /api/v1/certificates/{fingerprint}", a.authenticated(a.withStore(a.getCertificate)))
+	a.mux.HandleFunc("GET /api/v1/domains/{domain}/subdomains", a.authenticated(a.withStore(a.listSubdomains)))
+	a.mux.HandleFunc("GET /api/v1/stream", a.authenticated(a.streamMatches))
+	a.mux.HandleFunc("GET /api/v1/status", a.authenticated(a.getStatus))
+	a.mux.HandleFunc("GET /api/v1/config", a.authenticated(a.getConfig))
+	a.mux.HandleFunc("GET /api/v1/watchers", a.authenticated(a.managing(a.withStore(a.listWatchers))))
+	a.mux.HandleFunc("POST /api/v1/watchers", a.authenticated(a.managing(a.withStore(a.createWatcher))))
+	a.mux.HandleFunc("GET /api/v1/watchers/{id}", a.authenticated(a.managing(a.withStore(a.getWatcher))))
+	a.mux.HandleFunc("PUT /api/v1/watchers/{id}", a.authenticated(a.managing(a.withStore(a.updateWatcher))))
+	a.mux.HandleFunc("DELETE /api/v1/watchers/{id}", a.authenticated(a.managing(a.withStore(a.deleteWatcher))))
+	a.mux.Handle("GET /", dashboardHandler())
+	return a
+}
+
+// apiAccess is what the API key of a request grants.
+type apiAccess struct {
+	tenant string // only the data of this tenant, for keys of a tenant
+	manage bool   // managing watchers, for keys of api.apiKeys
+}
+
+type apiAccessKey struct{}
+
+func accessOf(r *http.Request) *apiAccess {
+	access, _ := r.Context().Value(apiAccessKey{}).(*apiAccess)
+	if access == nil {
+		return &apiAccess{}
+	}
+	return access
+}
+
+// tenant returns the tenant the request is limited to. Keys with access to
+// all tenants may pick one with ?tenant.
+func (access *apiAccess) tenantOf(r *http.Request) string {
+	if access.tenant != "" {
+		return access.tenant
+	}
+	return r.URL.Query().Get("tenant")
+}
+
+// authenticated resolves the bearer token of the request to its access. As
+// long as no API keys are configured the API is open, except for managing
+// watchers.
+func (a *API) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		type key struct {
+			token  string
+			access *apiAccess
+		}
+		var keys []key
+		if config := a.status.Config(); config != nil {
+			for _, token := range config.API.APIKeys {
+				keys = append(keys, key{token, &apiAccess{manage: true}})
+			}
+			for _, t := range config.Tenants {
+				for _, token := range t.APIKeys {
+					keys = append(keys, key{token, &apiAccess{tenant: t.Name}})
+				}
+			}
+		}
+
+		access := &apiAccess{}
+		if len(keys) > 0 {
+			access = nil
+			if token, ok := strings.CutPrefix(r.Header.Get("authorization"), "Bearer "); ok {
+				for _, k := range keys {
+					if subtle.ConstantTimeCompare([]byte(token), []byte(k.token)) == 1 {
+						access = k.access
+						break
+					}
+				}
+			}
+		}
+		if access == nil {
+			w.Header().Set("www-authenticate", `Bearer realm="certalert"`)
+			writeError(w, http.StatusUnauthorized, "a valid api key is required")
+			return
+		}
+		handler(w, r.WithContext(context.WithValue(r.Context(), apiAccessKey{}, access)))
+	}
+}
+
+// withStore responds with 503 to requests that need a storage if there is none.
+func (a *API) withStore(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.store == nil {
+			writeError(w, http.StatusServiceUnavailable, "no storage is configured")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("content-type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+func pageSize(r *http.Request) (int, error) {
+	raw := r.URL.Query().Get("limit")
+	if raw == "" {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > maxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+	}
+	return limit, nil
+}
+
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	raw := r.URL.Query().Get(name)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s must be an RFC 3339 timestamp", name)
+	}
+	return t, nil
+}
+
+type matchPage struct {
+	Matches    []*MatchRecord `json:"matches"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+func (a *API) listMatches(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := MatchFilter{
+		Domain:  strings.ToLower(strings.TrimSpace(query.Get("domain"))),
+		Watcher: query.Get("watcher"),
+		Tenant:  accessOf(r).tenantOf(r),
+		Issuer:  query.Get("issuer"),
+	}
+
+	var err error
+	if filter.Limit, err = pageSize(r); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Since, err = parseTimeParam(r, "since"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if filter.Until, err = parseTimeParam(r, "until"); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if cursor := query.Get("cursor"); cursor != "" {
+		if filter.Before, err = strconv.ParseInt(cursor, 10, 64); err != nil || filter.Before < 1 {
+			writeError(w, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+	}
+
+	matches, err := a.store.ListMatches(r.Context(), filter)
+	if err != nil {
+		logln("api: failed to list matches:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to list matches")
+		return
+	}
+
+	page := matchPage{Matches: matches}
+	if page.Matches == nil {
+		page.Matches = []*MatchRecord{}
+	}
+	if len(matches) == filter.Limit {
+		page.NextCursor = strconv.FormatInt(matches[len(matches)-1].ID, 10)
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+type certificateResponse struct {
+	*MatchRecord
+	PEM      string   `json:"pem"`
+	Watchers []string `json:"watchers"`
+}
+
+// getCertificate returns a certificate as JSON, or as PEM with ?format=pem or
+// an Accept header of application/x-pem-file.
+func (a *API) getCertificate(w http.ResponseWriter, r *http.Request) {
+	fingerprint := strings.ToLower(strings.ReplaceAll(r.PathValue("fingerprint"), ":", ""))
+	format := r.URL.Query().Get("format")
+	if strings.HasSuffix(fingerprint, ".pem") {
+		fingerprint, format = strings.TrimSuffix(fingerprint, ".pem"), "pem"
+	}
+
+	matches, err := a.store.MatchesByFingerprint(r.Context(), fingerprint)
+	if err != nil {
+		logln("api: failed to get certificate:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to get certificate")
+		return
+	}
+	if tenant := accessOf(r).tenantOf(r); tenant != "" {
+		var visible []*MatchRecord
+		for _, match := range matches {
+			if match.Tenant == tenant {
+				visible = append(visible, match)
+			}
+		}
+		matches = visible
+	}
+	if len(matches) == 0 {
+		writeError(w, http.StatusNotFound, "certificate not found")
+		return
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: matches[0].Raw})
+	if format == "pem" || strings.Contains(r.Header.Get("accept"), "application/x-pem-file") {
+		w.Header().Set("content-type", "application/x-pem-file")
+		w.Write(certPEM)
+		return
+	}
+
+	response := certificateResponse{MatchRecord: matches[0], PEM: string(certPEM)}
+	for _, match := range matches {
+		response.Watchers = append(response.Watchers, match.Watcher)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type namePage struct {
+	Domain     string        `json:"domain"`
+	Names      []*NameRecord `json:"names"`
+	NextCursor string        `json:"nextCursor,omitempty"`
+}
+
+func (a *API) listSubdomains(w http.ResponseWriter, r *http.Request) {
+	domain := getBaseDomain(strings.ToLower(strings.TrimSpace(r.PathValue("domain"))))
+	limit, err := pageSize(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// names are recorded per tenant, the default tenant unless one is given
+	names, err := a.store.ListNames(r.Context(), accessOf(r).tenantOf(r), domain, r.URL.Query().Get("cursor"), limit)
+	if err != nil {
+		logln("api: failed to list names:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to list names")
+		return
+	}
+
+	page := namePage{Domain: domain, Names: names}
+	if page.Names == nil {
+		page.Names = []*NameRecord{}
+	}
+	if len(names) == limit {
+		page.NextCursor = names[len(names)-1].Name
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// streamMatches sends new matches as server-sent events. Clients can filter
+// by watcher and domain, and resume after a disconnect with Last-Event-ID
+// (or ?lastEventId) as long as the events are still buffered.
+func (a *API) streamMatches(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+
+	query := r.URL.Query()
+	tenant := accessOf(r).tenantOf(r)
+	watcher := query.Get("watcher")
+	domain := strings.ToLower(strings.TrimSpace(query.Get("domain")))
+
+	rawLastID := r.Header.Get("last-event-id")
+	if rawLastID == "" {
+		rawLastID = query.Get("lastEventId")
+	}
+	var lastID uint64
+	if rawLastID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(rawLastID, 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid Last-Event-ID")
+			return
+		}
+	}
+
+	backlog, events := a.stream.Subscribe(lastID)
+	defer a.stream.Unsubscribe(events)
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set("x-accel-buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event *StreamEvent) error {
+		if !event.matches(tenant, watcher, domain) {
+			return nil
+		}
+		_, err := fmt.Fprintf(w, "id: %d\nevent: match\ndata: %s\n\n", event.ID, event.Data)
+		return err
+	}
+
+	for _, event := range backlog {
+		if send(event) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	// comments keep proxies from closing idle connections
+	heartbeat := time.NewTicker(15 * time.Second)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case event, ok := <-events:
+			if !ok {
+				// fell behind, the client reconnects with its Last-Event-ID
+				return
+			}
+			if send(event) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+func (a *API) getStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, a.status.Snapshot(accessOf(r).tenantOf(r)))
+}
+
+// getConfig returns the configuration in effect, without secrets.
+func (a *API) getConfig(w http.ResponseWriter, r *http.Request) {
+	if accessOf(r).tenant != "" {
+		writeError(w, http.StatusForbidden, "the configuration is not available to tenants")
+		return
+	}
+	config := a.status.Config()
+	if config == nil {
+		writeError(w, http.StatusServiceUnavailable, "no configuration is loaded")
+		return
+	}
+	b, err := config.RedactedYAML()
+	if err != nil {
+		logln("api: failed to encode config:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to encode config")
+		return
+	}
+	w.Header().Set("content-type", "application/yaml")
+	w.Write(b)
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testTenantsConfig = `
+logCollection:
+  logsURLs: [https://ct.example.com/log/]
+api:
+  apiKeys: [admin-test-key]
+watchers:
+  - glob: "*.example.com"
+    notifiers:
+      - shoutrrrURL: generic://hooks.example.com/default
+tenants:
+  - name: red
+    apiKeys: [red-test-key]
+    watchers:
+      - glob: "*.example.com"
+        notifiers:
+          - shoutrrrURL: generic://hooks.example.com/red
+  - name: blue
+    apiKeys: [blue-test-key]
+    watchers:
+      - glob: "*.example.com"
+        notifiers:
+          - shoutrrrURL: generic://hooks.example.com/blue
+`
+
+type testAPI struct {
+	*API
+	path string // of the config file
+}
+
+// newTestAPI serves the config with an SQLite store, both in a temp dir.
+func newTestAPI(t *testing.T, config string) *testAPI {
+	t.Helper()
+	return newTestAPIWithStore(t, config, nil)
+}
+
+// newTestAPIWithStore is newTestAPI with a setup of the store before the
+// config is loaded. The store setup returns is used by the API.
+func newTestAPIWithStore(t *testing.T, config string, setup func(store Store) Store) *testAPI {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yml")
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := newSQLiteStore(filepath.Join(dir, "certalert.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	var apiStore Store = store
+	if setup != nil {
+		apiStore = setup(store)
+	}
+	loaded, err := LoadConfigWithStore(path, apiStore, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := NewStatus()
+	reloader := NewReloader(path, apiStore, status, loaded)
+	return &testAPI{API: NewAPI(apiStore, NewStream(0), status, reloader), path: path}
+}
+
+func (a *testAPI) request(t *testing.T, method string, path string, key string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	if key != "" {
+		r.Header.Set("authorization", "Bearer "+key)
+	}
+	w := httptest.NewRecorder()
+	a.ServeHTTP(w, r)
+	return w
+}
+
+// recordTestMatch stores a match of a certificate for the tenant.
+func (a *testAPI) recordTestMatch(t *testing.T, tenant string, fingerprint string) *MatchRecord {
+	t.Helper()
+	m := &MatchRecord{
+		Fingerprint: fingerprint,
+		Serial:      fingerprint,
+		CommonName:  "www.example.com",
+		Names:       []string{"www.example.com"},
+		Watcher:     "*.example.com",
+		Tenant:      tenant,
+		Severity:    severityWarning,
+		Raw:         []byte{0},
+		SeenAt:      time.Now(),
+	}
+	if err := a.store.RecordMatch(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func decodeMatchPage(t *testing.T, w *httptest.ResponseRecorder) matchPage {
+	t.Helper()
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body.String())
+	}
+	var page matchPage
+	if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+		t.Fatal(err)
+	}
+	return page
+}
+
+func TestAPIAuthentication(t *testing.T) {
+	api := newTestAPI(t, testTenantsConfig)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		status int
+	}{
+		{name: "no key", method: "GET", path: "/api/v1/matches", status: http.StatusUnauthorized},
+		{name: "unknown key", method: "GET", path: "/api/v1/matches", key: "wrong-test-key", status: http.StatusUnauthorized},
+		{name: "key of the api reads", method: "GET", path: "/api/v1/matches", key: "admin-test-key", status: http.StatusOK},
+		{name: "key of a tenant reads", method: "GET", path: "/api/v1/matches", key: "red-test-key", status: http.StatusOK},
+		{name: "key of the api reads the config", method: "GET", path: "/api/v1/config", key: "admin-test-key", status: http.StatusOK},
+		{name: "key of the api manages watchers", method: "GET", path: "/api/v1/watchers", key: "admin-test-key", status: http.StatusOK},
+		{name: "key of a tenant can't list watchers", method: "GET", path: "/api/v1/watchers", key: "red-test-key", status: http.StatusForbidden},
+		{name: "key of a tenant can't create watchers", method: "POST", path: "/api/v1/watchers", key: "red-test-key", status: http.StatusForbidden},
+		{name: "key of a tenant can't delete watchers", method: "DELETE", path: "/api/v1/watchers/abc", key: "red-test-key", status: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := api.request(t, test.method, test.path, test.key, "")
+			if w.Code != test.status {
+				t.Errorf("got status %d, want %d: %s", w.Code, test.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestAPIOpenWithoutKeys(t *testing.T) {
+	api := newTestAPI(t, `
+logCollection:
+  logsURLs: [https://ct.example.com/log/]
+watchers:
+  - glob: "*.example.com"
+    notifiers:
+      - shoutrrrURL: generic://hooks.example.com/default
+`)
+
+	if w := api.request(t, "GET", "/api/v1/matches", "", ""); w.Code != http.StatusOK {
+		t.Errorf("reading matches: got status %d, want %d", w.Code, http.StatusOK)
+	}
+	// managing watchers always requires a key
+	if w := api.request(t, "GET", "/api/v1/watchers", "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("listing watchers: got status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestAPIMatchPagination(t *testing.T) {
+	api := newTestAPI(t, testTenantsConfig)
+	for i := 0; i < 5; i++ {
+		api.recordTestMatch(t, "", fmt.Sprintf("%064d", i))
+	}
+
+	var ids []int64
+	path := "/api/v1/matches?limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("pagination doesn't end")
+		}
+		page := decodeMatchPage(t, api.request(t, "GET", path, "admin-test-key", ""))
+		for _, m := range page.Matches {
+			ids = append(ids, m.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		path = "/api/v1/matches?limit=2&cursor=" + page.NextCursor
+	}
+
+	if len(ids) != 5 {
+		t.Fatalf("got %d matches over all pages, want 5: %v", len(ids), ids)
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] >= ids[i-1] {
+			t.Errorf("matches are not ordered newest first without repetitions: %v", ids)
+		}
+	}
+
+	for _, query := range []string{"limit=0", "limit=1001", "cursor=abc", "cursor=0"} {
+		if w := api.request(t, "GET", "/api/v1/matches?"+query, "admin-test-key", ""); w.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPITenantMatches(t *testing.T) {
+	api := newTestAPI(t, testTenantsConfig)
+	red := api.recordTestMatch(t, "red", strings.Repeat("a", 64))
+	blue := api.recordTestMatch(t, "blue", strings.Repeat("b", 64))
+
+	tests := []struct {
+		name string
+		key  string
+		path string
+		want []int64
+	}{
+		{name: "key of a tenant", key: "red-test-key", path: "/api/v1/matches", want: []int64{red.ID}},
+		{name: "key of a tenant asking for another tenant", key: "red-test-key", path: "/api/v1/matches?tenant=blue", want: []int64{red.ID}},
+		{name: "key of the api", key: "admin-test-key", path: "/api/v1/matches", want: []int64{blue.ID, red.ID}},
+		{name: "key of the api asking for a tenant", key: "admin-test-key", path: "/api/v1/matches?tenant=blue", want: []int64{blue.ID}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := decodeMatchPage(t, api.request(t, "GET", test.path, test.key, ""))
+			var got []int64
+			for _, m := range page.Matches {
+				got = append(got, m.ID)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("got matches %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAPITenantCertificatesAndNames(t *testing.T) {
+	// names are listed by registrable domain, don't download the list for that
+	publicSuffixOnce.Do(func() {
+		publicSuffixList = []Suffix{{Suffix: "com", SeperatorCount: 1}}
+	})
+
+	api := newTestAPI(t, testTenantsConfig)
+	blue := api.recordTestMatch(t, "blue", strings.Repeat("b", 64))
+	if err := api.store.RecordNames(context.Background(), "blue", []string{"blue.example.com"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.store.RecordNames(context.Background(), "red", []string{"red.example.com"}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	certificate := "/api/v1/certificates/" + blue.Fingerprint
+	for _, path := range []string{certificate, certificate + "?tenant=blue", certificate + ".pem"} {
+		if w := api.request(t, "GET", path, "red-test-key", ""); w.Code != http.StatusNotFound {
+			t.Errorf("%s with the key of another tenant: got status %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+	if w := api.request(t, "GET", certificate, "blue-test-key", ""); w.Code != http.StatusOK {
+		t.Errorf("%s with the key of the tenant: got status %d, want %d", certificate, w.Code, http.StatusOK)
+	}
+
+	tests := []struct {
+		key  string
+		path string
+		want string
+	}{
+		{key: "red-test-key", path: "/api/v1/domains/example.com/subdomains", want: "red.example.com"},
+		{key: "red-test-key", path: "/api/v1/domains/example.com/subdomains?tenant=blue", want: "red.example.com"},
+		{key: "admin-test-key", path: "/api/v1/domains/example.com/subdomains?tenant=blue", want: "blue.example.com"},
+	}
+	for _, test := range tests {
+		w := api.request(t, "GET", test.path, test.key, "")
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: got status %d: %s", test.path, w.Code, w.Body.String())
+		}
+		var page namePage
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Names) != 1 || page.Names[0].Name != test.want {
+			t.Errorf("%s with %s: got %d names, want only %s", test.path, test.key, len(page.Names), test.want)
+		}
+	}
+}
+
+func TestFirstSeenWatchers(t *testing.T) {
+	publicSuffixOnce.Do(func() {
+		publicSuffixList = []Suffix{{Suffix: "com", SeperatorCount: 1}}
+	})
+
+	watchers := []WatcherConfig{
+		{Name: "default"},
+		{Name: "red", Tenant: "red"},
+		{Name: "red again", Tenant: "red"},
+		{Name: "blue", Tenant: "blue"},
+	}
+	stores := map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store { return nil },
+		"sqlite": func(t *testing.T) Store {
+			store, err := newSQLiteStore(filepath.Join(t.TempDir(), "certalert.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+	}
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			store := newStore(t)
+			var seen sync.Map
+			entry, _ := issuedEntry(t, pkix.Name{CommonName: "R11", Organization: []string{"Let's Encrypt"}})
+
+			// every tenant is notified of the certificate once
+			got := firstSeenWatchers(store, &seen, entry.Certificate, watchers)
+			if len(got) != len(watchers) {
+				t.Errorf("got %d watchers at the first match, want %d", len(got), len(watchers))
+			}
+			if got := firstSeenWatchers(store, &seen, entry.Certificate, watchers); len(got) != 0 {
+				t.Errorf("got %d watchers at the second match, want 0", len(got))
+			}
+
+			// a tenant matching the certificate later is still notified
+			green := []WatcherConfig{{Name: "green", Tenant: "green"}, {Name: "red", Tenant: "red"}}
+			got = firstSeenWatchers(store, &seen, entry.Certificate, green)
+			if len(got) != 1 || got[0].Tenant != "green" {
+				t.Errorf("got %v, want only the watcher of tenant green", got)
+			}
+
+			if store == nil {
+				return
+			}
+			for _, tenant := range []string{"", "red", "blue", "green"} {
+				names, err := store.ListNames(context.Background(), tenant, "example.com", "", 10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(names) != 1 || names[0].Certificates != 1 {
+					t.Errorf("tenant %q: got %d names, want www.example.com counted once", tenant, len(names))
+				}
+			}
+		})
+	}
+}
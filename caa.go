@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+type CAAConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	Resolver     string   `yaml:"resolver"`     // host:port, defaults to the first nameserver in /etc/resolv.conf
+	Timeout      Duration `yaml:"timeout"`      // per query, 2s by default
+	CheckTimeout Duration `yaml:"checkTimeout"` // for all names of a certificate, 5s by default
+	CacheTTL     Duration `yaml:"cacheTTL"`     // how long looked up record sets are reused, 5m by default
+}
+
+type CAAStatus string
+
+const (
+	caaPermitted     CAAStatus = "permitted"
+	caaNoRecords     CAAStatus = "no CAA records"
+	caaViolation     CAAStatus = "VIOLATION"
+	caaUnknownIssuer CAAStatus = "unknown issuer"
+	caaLookupFailed  CAAStatus = "lookup failed"
+)
+
+// CAAVerdict is the result of checking one certificate name against the CAA
+// records that are currently published for it.
+type CAAVerdict struct {
+	Name    string
+	Domain  string   // the domain the relevant record set was found at
+	Records []string // the relevant records in presentation format
+	Status  CAAStatus
+	Err     error
+}
+
+func (v CAAVerdict) String() string {
+	switch v.Status {
+	case caaLookupFailed:
+		return fmt.Sprintf("%s: %s (%s)", v.Name, v.Status, v.Err.Error())
+	case caaNoRecords:
+		return fmt.Sprintf("%s: %s", v.Name, v.Status)
+	default:
+		return fmt.Sprintf("%s: %s (%s: %s)", v.Name, v.Status, v.Domain, strings.Join(v.Records, ", "))
+	}
+}
+
+// most names share their parent domains, which are looked up again and again
+const maxCAACacheEntries = 10000
+
+// names of a certificate whose record sets are looked up at the same time
+const maxConcurrentCAALookups = 8
+
+type caaCacheEntry struct {
+	records []*dns.CAA
+	expires time.Time
+}
+
+// caaLookup is a query in progress.
+type caaLookup struct {
+	done    chan struct{}
+	records []*dns.CAA
+	err     error
+}
+
+type CAAChecker struct {
+	resolver     string
+	client       *dns.Client
+	checkTimeout time.Duration
+	cacheTTL     time.Duration
+
+	mutex   sync.Mutex
+	cache   map[string]caaCacheEntry
+	pending map[string]*caaLookup
+}
+
+func NewCAAChecker(c CAAConfig) (*CAAChecker, error) {
+	resolver := c.Resolver
+	if resolver == "" {
+		resolvConf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+		if err != nil || len(resolvConf.Servers) == 0 {
+			return nil, fmt.Errorf("no resolver configured and none found in /etc/resolv.conf")
+		}
+		resolver = net.JoinHostPort(resolvConf.Servers[0], resolvConf.Port)
+	}
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+
+	timeout := c.Timeout.Duration
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+
+	checkTimeout := c.CheckTimeout.Duration
+	if checkTimeout == 0 {
+		checkTimeout = 5 * time.Second
+	}
+
+	cacheTTL := c.CacheTTL.Duration
+	if cacheTTL == 0 {
+		cacheTTL = 5 * time.Minute
+	}
+
+	return &CAAChecker{
+		resolver:     resolver,
+		client:       &dns.Client{Timeout: timeout},
+		checkTimeout: checkTimeout,
+		cacheTTL:     cacheTTL,
+		cache:        map[string]caaCacheEntry{},
+		pending:      map[string]*caaLookup{},
+	}, nil
+}
+
+// lookup returns the CAA records of the domain. Answers, including empty
+// ones, are cached; failed lookups are not. Names looked up at the same time
+// share one query, since the names of a certificate usually share parents.
+func (c *CAAChecker) lookup(ctx context.Context, domain string) ([]*dns.CAA, error) {
+	now := time.Now()
+	c.mutex.Lock()
+	entry, ok := c.cache[domain]
+	if ok && now.Before(entry.expires) {
+		c.mutex.Unlock()
+		return entry.records, nil
+	}
+	if pending, ok := c.pending[domain]; ok {
+		c.mutex.Unlock()
+		select {
+		case <-pending.done:
+			return pending.records, pending.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	pending := &caaLookup{done: make(chan struct{})}
+	c.pending[domain] = pending
+	c.mutex.Unlock()
+
+	pending.records, pending.err = c.query(ctx, domain)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.pending, domain)
+	close(pending.done)
+	if pending.err != nil {
+		return nil, pending.err
+	}
+
+	if len(c.cache) >= maxCAACacheEntries {
+		for cached, entry := range c.cache {
+			if !now.Before(entry.expires) {
+				delete(c.cache, cached)
+			}
+		}
+		if len(c.cache) >= maxCAACacheEntries {
+			c.cache = map[string]caaCacheEntry{}
+		}
+	}
+	c.cache[domain] = caaCacheEntry{records: pending.records, expires: now.Add(c.cacheTTL)}
+	return pending.records, nil
+}
+
+func (c *CAAChecker) query(ctx context.Context, domain string) ([]*dns.CAA, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(domain), dns.TypeCAA)
+	msg.RecursionDesired = true
+
+	resp, _, err := c.client.ExchangeContext(ctx, msg, c.resolver)
+	if err == nil && resp.Truncated {
+		tcp := *c.client
+		tcp.Net = "tcp"
+		resp, _, err = tcp.ExchangeContext(ctx, msg, c.resolver)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+		return nil, fmt.Errorf("resolver returned %s for %s", dns.RcodeToString[resp.Rcode], domain)
+	}
+
+	// the answer may contain the CNAME chain that led to the records
+	var records []*dns.CAA
+	for _, rr := range resp.Answer {
+		if caa, ok := rr.(*dns.CAA); ok {
+			records = append(records, caa)
+		}
+	}
+	return records, nil
+}
+
+// relevantRecordSet climbs the DNS tree from the domain towards the root
+// until it finds CAA records, as described in RFC 8659 section 3.
+func (c *CAAChecker) relevantRecordSet(ctx context.Context, domain string) (string, []*dns.CAA, error) {
+	domain = strings.TrimSuffix(domain, ".")
+	for domain != "" {
+		records, err := c.lookup(ctx, domain)
+		if err != nil {
+			return domain, nil, err
+		}
+		if len(records) > 0 {
+			return domain, records, nil
+		}
+		_, parent, found := strings.Cut(domain, ".")
+		if !found {
+			break
+		}
+		domain = parent
+	}
+	return "", nil, nil
+}
+
+// issuerCAAIdentifiers returns every known CAA identifier the issuer of the
+// certificate could be using.
+func issuerCAAIdentifiers(cert *x509.Certificate) []string {
+	var identifiers []string
+	for identifier := range knownCAAIdentifiers {
+		if caaIdentifierMatchesIssuer(identifier, cert) {
+			identifiers = append(identifiers, identifier)
+		}
+	}
+	sort.Strings(identifiers)
+	return identifiers
+}
+
+// caaPermits evaluates a relevant record set for the given CA identifiers.
+func caaPermits(records []*dns.CAA, identifiers []string, wildcard bool) bool {
+	var issue, issuewild []*dns.CAA
+	for _, record := range records {
+		switch strings.ToLower(record.Tag) {
+		case "issue":
+			issue = append(issue, record)
+		case "issuewild":
+			issuewild = append(issuewild, record)
+		case "iodef", "issuemail", "issuevmc", "contactemail", "contactphone":
+		default:
+			// unknown properties with the critical flag forbid issuance
+			if record.Flag&128 != 0 {
+				return false
+			}
+		}
+	}
+
+	relevant := issue
+	if wildcard && len(issuewild) > 0 {
+		relevant = issuewild
+	}
+	if len(relevant) == 0 {
+		return true
+	}
+
+	for _, record := range relevant {
+		domain, _, _ := strings.Cut(record.Value, ";")
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		for _, identifier := range identifiers {
+			if domain == identifier {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check evaluates the CAA records of every DNS name of the certificate. The
+// records are looked up at the time of the check, not at the time the
+// certificate was issued. The names are looked up concurrently, and names
+// that couldn't be looked up within the check timeout are reported as failed,
+// so that a slow resolver doesn't hold up the dispatch workers.
+func (c *CAAChecker) Check(cert *x509.Certificate) []CAAVerdict {
+	identifiers := issuerCAAIdentifiers(cert)
+
+	var names []string
+	seen := map[string]bool{}
+	for _, name := range certificateNames(cert) {
+		if seen[name] || net.ParseIP(name) != nil {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.checkTimeout)
+	defer cancel()
+
+	verdicts := make([]CAAVerdict, len(names))
+	limit := make(chan struct{}, maxConcurrentCAALookups)
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		limit <- struct{}{}
+		go func(i int, name string) {
+			defer wg.Done()
+			defer func() { <-limit }()
+			verdicts[i] = c.checkName(ctx, name, identifiers)
+		}(i, name)
+	}
+	wg.Wait()
+	return verdicts
+}
+
+func (c *CAAChecker) checkName(ctx context.Context, name string, identifiers []string) CAAVerdict {
+	lookupName, wildcard := strings.CutPrefix(name, "*.")
+
+	verdict := CAAVerdict{Name: name}
+	domain, records, err := c.relevantRecordSet(ctx, lookupName)
+	verdict.Domain = domain
+	for _, record := range records {
+		verdict.Records = append(verdict.Records, fmt.Sprintf("%d %s %q", record.Flag, record.Tag, record.Value))
+	}
+
+	switch {
+	case err != nil:
+		verdict.Status = caaLookupFailed
+		verdict.Err = err
+	case len(records) == 0:
+		verdict.Status = caaNoRecords
+	case caaPermits(records, identifiers, wildcard):
+		verdict.Status = caaPermitted
+	case len(identifiers) == 0:
+		verdict.Status = caaUnknownIssuer
+	default:
+		verdict.Status = caaViolation
+	}
+	return verdict
+}
+
+// hasCAAViolation reports whether any of the verdicts is a violation.
+func hasCAAViolation(verdicts []CAAVerdict) bool {
+	for _, v := range verdicts {
+		if v.Status == caaViolation {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCAAInconclusive reports whether any name couldn't be checked.
+func hasCAAInconclusive(verdicts []CAAVerdict) bool {
+	for _, v := range verdicts {
+		if v.Status == caaLookupFailed || v.Status == caaUnknownIssuer {
+			return true
+		}
+	}
+	return false
+}
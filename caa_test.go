@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// caaTestResolver answers CAA queries from a fixed zone and counts them.
+type caaTestResolver struct {
+	zone    map[string][]string // domain to records in presentation format, e.g. `0 issue "letsencrypt.org"`
+	failing map[string]bool     // domains answered with SERVFAIL
+	slow    map[string]bool     // domains answered after a second
+
+	mutex   sync.Mutex
+	queries map[string]int
+}
+
+func (r *caaTestResolver) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	domain := strings.TrimSuffix(req.Question[0].Name, ".")
+	r.mutex.Lock()
+	r.queries[domain]++
+	r.mutex.Unlock()
+
+	if r.slow[domain] {
+		time.Sleep(time.Second)
+	}
+
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	if r.failing[domain] {
+		resp.Rcode = dns.RcodeServerFailure
+	}
+	for _, record := range r.zone[domain] {
+		rr, err := dns.NewRR(dns.Fqdn(domain) + " 300 IN CAA " + record)
+		if err != nil {
+			panic(err)
+		}
+		resp.Answer = append(resp.Answer, rr)
+	}
+	w.WriteMsg(resp)
+}
+
+func (r *caaTestResolver) queryCount(domain string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.queries[domain]
+}
+
+// startCAATestResolver serves the resolver on a local UDP port and returns a
+// checker that uses it.
+func startCAATestResolver(t *testing.T, resolver *caaTestResolver, config CAAConfig) *CAAChecker {
+	t.Helper()
+	resolver.queries = map[string]int{}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	server := &dns.Server{PacketConn: conn, Handler: resolver, NotifyStartedFunc: func() { close(started) }}
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	config.Resolver = conn.LocalAddr().String()
+	checker, err := NewCAAChecker(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return checker
+}
+
+func caaRecords(t *testing.T, records ...string) []*dns.CAA {
+	t.Helper()
+	var out []*dns.CAA
+	for _, record := range records {
+		rr, err := dns.NewRR("example.com. 300 IN CAA " + record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, rr.(*dns.CAA))
+	}
+	return out
+}
+
+func TestCAAPermits(t *testing.T) {
+	tests := []struct {
+		name     string
+		records  []string
+		wildcard bool
+		permits  bool
+	}{
+		{name: "issue", records: []string{`0 issue "letsencrypt.org"`}, permits: true},
+		{name: "issue with parameters", records: []string{`0 issue "LetsEncrypt.org; validationmethods=dns-01"`}, permits: true},
+		{name: "issue for another CA", records: []string{`0 issue "digicert.com"`}},
+		{name: "one of several issue records", records: []string{`0 issue "digicert.com"`, `0 issue "letsencrypt.org"`}, permits: true},
+		{name: "empty issue forbids issuance", records: []string{`0 issue ";"`}},
+		{name: "empty issue value forbids issuance", records: []string{`0 issue ""`}},
+		{name: "only iodef", records: []string{`0 iodef "mailto:security@example.com"`}, permits: true},
+		{name: "unknown critical property", records: []string{`0 issue "letsencrypt.org"`, `128 tbs "unknown"`}},
+		{name: "unknown non-critical property", records: []string{`0 issue "letsencrypt.org"`, `0 tbs "unknown"`}, permits: true},
+		{name: "issuewild takes precedence for wildcards", records: []string{`0 issue "digicert.com"`, `0 issuewild "letsencrypt.org"`}, wildcard: true, permits: true},
+		{name: "issuewild forbids wildcards", records: []string{`0 issue "letsencrypt.org"`, `0 issuewild ";"`}, wildcard: true},
+		{name: "issuewild doesn't apply to other names", records: []string{`0 issue "digicert.com"`, `0 issuewild "letsencrypt.org"`}},
+		{name: "issue applies to wildcards without issuewild", records: []string{`0 issue "digicert.com"`}, wildcard: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := caaPermits(caaRecords(t, test.records...), []string{"letsencrypt.org"}, test.wildcard); got != test.permits {
+				t.Errorf("caaPermits() = %t, want %t", got, test.permits)
+			}
+		})
+	}
+}
+
+func TestCAACheck(t *testing.T) {
+	resolver := &caaTestResolver{
+		zone: map[string][]string{
+			"example.com":          {`0 issue "letsencrypt.org"`},
+			"digicert.example.com": {`0 issue "digicert.com"`},
+			"wild.example.com":     {`0 issue "digicert.com"`, `0 issuewild "letsencrypt.org"`},
+		},
+		failing: map[string]bool{"broken.example.com": true},
+	}
+	checker := startCAATestResolver(t, resolver, CAAConfig{})
+
+	letsEncrypt := pkix.Name{CommonName: "R11", Organization: []string{"Let's Encrypt"}}
+	tests := []struct {
+		name   string
+		issuer pkix.Name
+		domain string
+		status CAAStatus
+	}{
+		{name: "www.example.com", issuer: letsEncrypt, domain: "example.com", status: caaPermitted},
+		{name: "a.b.example.com", issuer: letsEncrypt, domain: "example.com", status: caaPermitted},
+		{name: "www.digicert.example.com", issuer: letsEncrypt, domain: "digicert.example.com", status: caaViolation},
+		{name: "*.wild.example.com", issuer: letsEncrypt, domain: "wild.example.com", status: caaPermitted},
+		{name: "wild.example.com", issuer: letsEncrypt, domain: "wild.example.com", status: caaViolation},
+		{name: "www.example.net", issuer: letsEncrypt, status: caaNoRecords},
+		{name: "www.broken.example.com", issuer: letsEncrypt, domain: "broken.example.com", status: caaLookupFailed},
+		{name: "www.example.com", issuer: pkix.Name{CommonName: "Private CA", Organization: []string{"Example Corp"}}, domain: "example.com", status: caaUnknownIssuer},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verdicts := checker.Check(&x509.Certificate{Issuer: test.issuer, DNSNames: []string{test.name}})
+			if len(verdicts) != 1 {
+				t.Fatalf("got %d verdicts, want 1", len(verdicts))
+			}
+			if verdicts[0].Status != test.status || verdicts[0].Domain != test.domain {
+				t.Errorf("got %s at %q, want %s at %q", verdicts[0].Status, verdicts[0].Domain, test.status, test.domain)
+			}
+		})
+	}
+}
+
+func TestCAACache(t *testing.T) {
+	resolver := &caaTestResolver{
+		zone:    map[string][]string{"example.com": {`0 issue "letsencrypt.org"`}},
+		failing: map[string]bool{"broken.example.com": true},
+	}
+	checker := startCAATestResolver(t, resolver, CAAConfig{})
+
+	cert := &x509.Certificate{
+		Issuer:   pkix.Name{Organization: []string{"Let's Encrypt"}},
+		DNSNames: []string{"a.example.com", "b.example.com", "www.broken.example.com"},
+	}
+	checker.Check(cert)
+	checker.Check(cert)
+
+	// the record set of the parent is looked up once for all names
+	if got := resolver.queryCount("example.com"); got != 1 {
+		t.Errorf("example.com was looked up %d times, want 1", got)
+	}
+	if got := resolver.queryCount("a.example.com"); got != 1 {
+		t.Errorf("a.example.com was looked up %d times, want 1", got)
+	}
+	// failed lookups are retried
+	if got := resolver.queryCount("broken.example.com"); got != 2 {
+		t.Errorf("broken.example.com was looked up %d times, want 2", got)
+	}
+}
+
+func TestCAACheckTimeout(t *testing.T) {
+	resolver := &caaTestResolver{
+		zone: map[string][]string{"example.com": {`0 issue "letsencrypt.org"`}},
+		slow: map[string]bool{"slow.example.com": true, "slower.example.com": true},
+	}
+	checker := startCAATestResolver(t, resolver, CAAConfig{
+		Timeout:      Duration{Duration: 2 * time.Second},
+		CheckTimeout: Duration{Duration: 200 * time.Millisecond},
+	})
+
+	start := time.Now()
+	verdicts := checker.Check(&x509.Certificate{
+		Issuer:   pkix.Name{Organization: []string{"Let's Encrypt"}},
+		DNSNames: []string{"www.example.com", "slow.example.com", "slower.example.com"},
+	})
+	if elapsed := time.Since(start); elapsed > 800*time.Millisecond {
+		t.Errorf("check took %s, want it bounded by the check timeout", elapsed)
+	}
+
+	want := []CAAStatus{caaPermitted, caaLookupFailed, caaLookupFailed}
+	if len(verdicts) != len(want) {
+		t.Fatalf("got %d verdicts, want %d", len(verdicts), len(want))
+	}
+	for i, verdict := range verdicts {
+		if verdict.Status != want[i] {
+			t.Errorf("%s: got %s, want %s", verdict.Name, verdict.Status, want[i])
+		}
+	}
+}
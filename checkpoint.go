@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// loadCheckpoint restores the tree sizes up to which the logs were processed,
+// so that entries logged while certalert was stopped are not skipped. The
+// checkpoint is read from the file and the store, whichever are configured;
+// the further position wins.
+func loadCheckpoint(path string, store Store, lastTreeSizes *sync.Map) error {
+	restore := func(treeSizes map[string]int64) {
+		for logID, treeSize := range treeSizes {
+			if current, ok := lastTreeSizes.Load(logID); ok && current.(int64) >= treeSize {
+				continue
+			}
+			lastTreeSizes.Store(logID, treeSize)
+		}
+	}
+
+	if path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err == nil {
+			treeSizes := map[string]int64{}
+			if err := json.Unmarshal(b, &treeSizes); err != nil {
+				return err
+			}
+			restore(treeSizes)
+		}
+	}
+
+	if store != nil {
+		treeSizes, err := store.LoadCheckpoint(context.Background())
+		if err != nil {
+			return err
+		}
+		restore(treeSizes)
+	}
+	return nil
+}
+
+func saveCheckpoint(path string, store Store, lastTreeSizes *sync.Map) error {
+	treeSizes := map[string]int64{}
+	lastTreeSizes.Range(func(key, value any) bool {
+		if treeSize, ok := value.(int64); ok && treeSize > 0 {
+			treeSizes[key.(string)] = treeSize
+		}
+		return true
+	})
+
+	if store != nil {
+		if err := store.SaveCheckpoint(context.Background(), treeSizes); err != nil {
+			return err
+		}
+	}
+	if path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(treeSizes, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	ctgo "github.com/google/certificate-transparency-go"
+)
+
+const defaultConfigPath = "config/config.yml"
+
+const usage = `usage: cert-alert [command] [flags]
+
+commands:
+  run          watch the logs and send notifications (default)
+  validate     check the config and report all problems
+  test-match   show which watchers match a name or certificate
+  list-logs    show the logs that are watched
+
+Run 'cert-alert <command> -h' for the flags of a command.
+`
+
+func main() {
+	// libraries such as Shoutrrr log through the log package
+	log.SetOutput(redactingWriter{os.Stderr})
+	os.Exit(execute(os.Args[1:]))
+}
+
+// execute runs the command given by args and returns the exit status.
+// Without a command, run is assumed.
+func execute(args []string) int {
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "run":
+		return runCommand(args)
+	case "validate":
+		return validateCommand(args)
+	case "test-match":
+		return testMatchCommand(args)
+	case "list-logs":
+		return listLogsCommand(args)
+	case "help":
+		fmt.Print(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", command, usage)
+		return 2
+	}
+}
+
+// newFlagSet returns the flags of a command, all of which take --config.
+func newFlagSet(command string, arguments string) (*flag.FlagSet, *string) {
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	configPath := flags.String("config", defaultConfigPath, "path of the config file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: cert-alert %s [flags] %s\n\nflags:\n", command, arguments)
+		flags.PrintDefaults()
+	}
+	return flags, configPath
+}
+
+// parseFlags returns the exit status if the command shouldn't run.
+func parseFlags(flags *flag.FlagSet, args []string, positional int) (int, bool) {
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, false
+		}
+		return 2, false
+	}
+	if flags.NArg() != positional {
+		flags.Usage()
+		return 2, false
+	}
+	return 0, true
+}
+
+func runCommand(args []string) int {
+	flags, configPath := newFlagSet("run", "")
+	if status, ok := parseFlags(flags, args, 0); !ok {
+		return status
+	}
+	return run(*configPath)
+}
+
+// configProblems splits the error of LoadConfig into the single problems.
+func configProblems(err error) []error {
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var problems []error
+	for _, e := range joined.Unwrap() {
+		problems = append(problems, configProblems(e)...)
+	}
+	return problems
+}
+
+// loadConfigOrReport loads the config and prints its problems if it's invalid.
+func loadConfigOrReport(path string) *Config {
+	config, err := LoadConfigFile(path)
+	if err != nil {
+		problems := configProblems(err)
+		if len(problems) == 1 {
+			fmt.Fprintf(os.Stderr, "%s: 1 problem found\n", path)
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %d problems found\n", path, len(problems))
+		}
+		for _, problem := range problems {
+			fmt.Fprintln(os.Stderr, "  -", strings.ReplaceAll(problem.Error(), "\n", "\n    "))
+		}
+		return nil
+	}
+	return config
+}
+
+func validateCommand(args []string) int {
+	flags, configPath := newFlagSet("validate", "")
+	if status, ok := parseFlags(flags, args, 0); !ok {
+		return status
+	}
+	config := loadConfigOrReport(*configPath)
+	if config == nil {
+		return 1
+	}
+	fmt.Printf("%s: ok, %d watchers in %d tenants\n", *configPath, len(config.Watchers), len(config.Tenants))
+	return 0
+}
+
+// testMatchEntry reads the certificate to test from a PEM file, stdin ("-")
+// or the argument itself. Anything else is taken as a DNS name, for which a
+// certificate with just that name is made up. A second certificate in the
+// PEM is used as the issuer.
+func testMatchEntry(arg string) (*CertificateEntry, error) {
+	entry := &CertificateEntry{Log: CtLogUpdateLog{OperatorName: "cert-alert", Description: "test-match"}}
+
+	var pemBytes []byte
+	switch {
+	case arg == "-":
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("read stdin: %w", err)
+		}
+		pemBytes = b
+	case strings.Contains(arg, "-----BEGIN"):
+		pemBytes = []byte(arg)
+	default:
+		if info, err := os.Stat(arg); err == nil && !info.IsDir() {
+			b, err := os.ReadFile(arg)
+			if err != nil {
+				return nil, fmt.Errorf("read file: %w", err)
+			}
+			pemBytes = b
+		}
+	}
+
+	if pemBytes == nil {
+		name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(arg), "."))
+		if name == "" || strings.ContainsAny(name, " /") {
+			return nil, fmt.Errorf("%q is neither a DNS name nor a PEM file", arg)
+		}
+		entry.Certificate = &x509.Certificate{
+			Subject:      pkix.Name{CommonName: name},
+			Issuer:       pkix.Name{CommonName: "cert-alert test-match"},
+			DNSNames:     []string{name},
+			SerialNumber: big.NewInt(1),
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+			Raw:          []byte(name),
+		}
+		return entry, nil
+	}
+
+	for {
+		var block *pem.Block
+		block, pemBytes = pem.Decode(pemBytes)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if entry.Certificate == nil {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("parse certificate: %w", err)
+			}
+			entry.Certificate = cert
+			continue
+		}
+		entry.Chain = append(entry.Chain, ctgo.ASN1Cert{Data: block.Bytes})
+	}
+	if entry.Certificate == nil {
+		return nil, fmt.Errorf("no certificate found in the PEM")
+	}
+	return entry, nil
+}
+
+func testMatchCommand(args []string) int {
+	flags, configPath := newFlagSet("test-match", "<name|pem-file|->")
+	if status, ok := parseFlags(flags, args, 1); !ok {
+		return status
+	}
+	config := loadConfigOrReport(*configPath)
+	if config == nil {
+		return 1
+	}
+	entry, err := testMatchEntry(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+
+	cert := entry.Certificate
+	fmt.Println("names: ", strings.Join(certificateNames(cert), ", "))
+	fmt.Println("issuer:", cert.Issuer.String())
+	fmt.Println()
+
+	matched := map[string]bool{}
+	for _, w := range config.WatchersForCertificate(entry) {
+		matched[w.key] = true
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for i := range config.Watchers {
+		w := &config.Watchers[i]
+		verdict := "no match"
+		if matched[w.key] {
+			verdict = "match"
+		}
+		label := w.path(i)
+		if w.Name != "" {
+			label += " (" + w.Name + ")"
+		}
+		if w.Tenant != "" {
+			label += " tenant " + w.Tenant
+		}
+		fmt.Fprintf(out, "%s: %s\n", label, verdict)
+
+		data := NewTemplateData(NotifyInstruction{Certificate: cert, Entry: entry}, w, nil)
+		switch {
+		case w.kind == pkNone:
+			fmt.Fprintln(out, "  names:\tnot checked, the watcher has no name pattern")
+		case len(data.MatchedNames) > 0:
+			fmt.Fprintf(out, "  names:\t%s %s %q\n", strings.Join(data.MatchedNames, ", "), patternKindName(w.kind), patternOf(w))
+		default:
+			fmt.Fprintf(out, "  names:\tnone match %s %q\n", patternKindName(w.kind), patternOf(w))
+		}
+		if w.Certificate != nil {
+			if w.Certificate.Match(cert) {
+				fmt.Fprintln(out, "  certificate:\tconditions hold")
+			} else {
+				fmt.Fprintln(out, "  certificate:\tconditions don't hold")
+			}
+		}
+		if w.AllowedIssuers != nil {
+			if w.AllowedIssuers.Allows(entry) {
+				fmt.Fprintln(out, "  issuer:\tallowed, only unauthorized issuers match")
+			} else {
+				fmt.Fprintln(out, "  issuer:\tnot allowed")
+			}
+		}
+		if w.when != nil {
+			ok, err := evalExpression(w.when, NewCertificateEnv(entry))
+			if err != nil {
+				fmt.Fprintf(out, "  when:\tfailed: %s\n", err.Error())
+			} else {
+				fmt.Fprintf(out, "  when:\t%t\n", ok)
+			}
+		}
+		if matched[w.key] {
+			fmt.Fprintf(out, "  severity:\t%s\n", data.Severity)
+		}
+	}
+	out.Flush()
+
+	if len(matched) == 0 {
+		return 1
+	}
+	return 0
+}
+
+func patternKindName(kind patternKind) string {
+	if kind == pkRegex {
+		return "regexp"
+	}
+	return "glob"
+}
+
+func patternOf(w *WatcherConfig) string {
+	if w.kind == pkRegex {
+		return w.RegexpRaw
+	}
+	return w.Glob
+}
+
+func listLogsCommand(args []string) int {
+	flags, configPath := newFlagSet("list-logs", "")
+	if status, ok := parseFlags(flags, args, 0); !ok {
+		return status
+	}
+	config := loadConfigOrReport(*configPath)
+	if config == nil {
+		return 1
+	}
+
+	logUpdate := CtLogUpdate{}
+	if config.LogCollection.GoogleLogListURL != "" {
+		var err error
+		logUpdate, err = getGoogleCTLogs(config.LogCollection.GoogleLogListURL, "")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "failed to get log list:", err.Error())
+			return 1
+		}
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(out, "OPERATOR\tDESCRIPTION\tURL")
+	for _, log := range effectiveLogs(logUpdate, config) {
+		fmt.Fprintf(out, "%s\t%s\t%s\n", log.OperatorName, log.Description, log.Url)
+	}
+	out.Flush()
+	return 0
+}
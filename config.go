@@ -1,18 +1,20 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
 	"regexp"
 	"strings"
+	"text/template"
 	"time"
 
 	"github.com/containrrr/shoutrrr"
-	"github.com/containrrr/shoutrrr/pkg/router"
-	"github.com/containrrr/shoutrrr/pkg/types"
+	"github.com/expr-lang/expr/vm"
 	"github.com/gobwas/glob"
 	"gopkg.in/yaml.v3"
+
+	"janic0/cert-alert/matcher"
 )
 
 type Duration struct{ time.Duration }
@@ -30,10 +32,30 @@ func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
 	return nil
 }
 
+func (d Duration) MarshalYAML() (any, error) {
+	return d.String(), nil
+}
+
 type Config struct {
 	Prometheus    PrometheusConfig    `yaml:"prometheus"`
 	LogCollection LogCollectionConfig `yaml:"logCollection"`
+	CAA           CAAConfig           `yaml:"caa"`
+	Templates     TemplateConfig      `yaml:"templates"`
+	Outbox        OutboxConfig        `yaml:"outbox"`
+	Dispatch      DispatchConfig      `yaml:"dispatch"`
+	Storage       StorageConfig       `yaml:"storage"`
+	API           APIConfig           `yaml:"api"`
 	Watchers      []WatcherConfig     `yaml:"watchers"`
+	Tenants       []TenantConfig      `yaml:"tenants"`
+	Notifiers     []NotifierConfig    `yaml:"notifiers"` // named notifiers the watchers refer to
+	Include       []string            `yaml:"include"`   // files with more watchers, relative to the config file
+
+	ShutdownTimeout Duration `yaml:"shutdownTimeout"` // how long to wait for in-flight work on shutdown, 30s by default
+
+	caa       *CAAChecker                `yaml:"-"`
+	matcher   *matcher.Matcher           `yaml:"-"` // name patterns of all watchers, ids are watcher indices
+	tenants   map[string]*TenantConfig   `yaml:"-"`
+	notifiers map[string]*NotifierConfig `yaml:"-"` // valid named notifiers
 }
 
 type PrometheusConfig struct {
@@ -46,10 +68,85 @@ type LogCollectionConfig struct {
 
 	GoogleLogListURL string   `yaml:"googleLogListURL"`
 	LogsURLs         []string `yaml:"logsURLs"`
+
+	CheckpointFile string `yaml:"checkpointFile"` // where the processed tree size of each log is saved
 }
 
 type NotifierConfig struct {
-	ShoutrrrURL string `yaml:"shoutrrrURL"`
+	Name        string         `yaml:"name"`     // of a top-level notifier
+	Notifier    string         `yaml:"notifier"` // the name of a top-level notifier to use instead
+	ShoutrrrURL string         `yaml:"shoutrrrURL"`
+	Webhook     *WebhookConfig `yaml:"webhook"` // JSON webhook instead of a Shoutrrr service
+	Template    TemplateConfig `yaml:"template"`
+
+	RateLimit  *RateLimitConfig   `yaml:"rateLimit"`  // at most count notifications per duration
+	QuietHours []QuietHoursConfig `yaml:"quietHours"` // windows in which nothing is sent
+	Suppressed string             `yaml:"suppressed"` // drop (default), delay or digest
+	Severities []string           `yaml:"severities"` // only notify about these severities, all by default
+	Workers    int                `yaml:"workers"`    // concurrent deliveries, 1 by default
+
+	tenant string `yaml:"-"` // of the watcher, keeps deliveries of tenants apart
+	source string `yaml:"-"` // the included file a top-level notifier is defined in
+
+	title         *template.Template `yaml:"-"`
+	message       *template.Template `yaml:"-"`
+	digestTitle   *template.Template `yaml:"-"`
+	digestMessage *template.Template `yaml:"-"`
+	severities    map[Severity]bool  `yaml:"-"`
+}
+
+func (n *NotifierConfig) target() DeliveryTarget {
+	return DeliveryTarget{ShoutrrrURL: n.ShoutrrrURL, Webhook: n.Webhook, Workers: n.Workers, Tenant: n.tenant}
+}
+
+// Delivery renders the notifier's templates for a match.
+func (n *NotifierConfig) Delivery(data *TemplateData) (*Delivery, error) {
+	title, err := executeTemplate(n.title, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render title: %w", err)
+	}
+	message, err := executeTemplate(n.message, data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render message: %w", err)
+	}
+
+	d := &Delivery{Target: n.target(), Title: title, Message: message}
+	if data.matchID != 0 {
+		d.MatchIDs = []int64{data.matchID}
+	}
+	if n.Webhook != nil {
+		if d.Body, err = MatchWebhookBody(title, message, data); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// DigestDelivery renders the notifier's digest templates.
+func (n *NotifierConfig) DigestDelivery(digest *DigestData) (*Delivery, error) {
+	title, err := executeTemplate(n.digestTitle, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render digest title: %w", err)
+	}
+	message, err := executeTemplate(n.digestMessage, digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to render digest message: %w", err)
+	}
+
+	d := &Delivery{Target: n.target(), Title: title, Message: message}
+	for _, group := range digest.Groups {
+		for _, match := range group.Matches {
+			if match.matchID != 0 {
+				d.MatchIDs = append(d.MatchIDs, match.matchID)
+			}
+		}
+	}
+	if n.Webhook != nil {
+		if d.Body, err = DigestWebhookBody(title, message, digest); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
 }
 
 type patternKind uint8
@@ -61,14 +158,35 @@ const (
 )
 
 type WatcherConfig struct {
-	Glob      string           `yaml:"glob"`   // wildcard pattern
-	RegexpRaw string           `yaml:"regexp"` // raw regular expression
-	Notifiers []NotifierConfig `yaml:"notifiers"`
+	Name           string                    `yaml:"name"`           // optional, shown in notifications
+	Tenant         string                    `yaml:"tenant"`         // set for the watchers of a tenant
+	Glob           string                    `yaml:"glob"`           // wildcard pattern
+	RegexpRaw      string                    `yaml:"regexp"`         // raw regular expression
+	Certificate    *CertificateMatcherConfig `yaml:"certificate"`    // certificate field conditions
+	When           string                    `yaml:"when"`           // expression evaluated after the patterns matched
+	AllowedIssuers *AllowedIssuersConfig     `yaml:"allowedIssuers"` // only match certificates not issued by these CAs
+	Severity       string                    `yaml:"severity"`       // info, warning (default) or critical
+	SeverityRules  []SeverityRuleConfig      `yaml:"severityRules"`  // raise the severity of matches
+	Template       TemplateConfig            `yaml:"template"`
+	Digest         *DigestConfig             `yaml:"digest"` // batch matches instead of notifying for each one
+	Notifiers      []NotifierConfig          `yaml:"notifiers"`
+
+	key      string         `yaml:"-"` // identifies the watcher across configuration reloads
+	kind     patternKind    `yaml:"-"`
+	re       *regexp.Regexp `yaml:"-"`
+	gl       glob.Glob      `yaml:"-"`
+	when     *vm.Program    `yaml:"-"`
+	severity Severity       `yaml:"-"`
+	origin   string         `yaml:"-"` // names watchers not defined in the config file in errors
+	index    int            `yaml:"-"` // position in Config.Watchers, for errors after loading
+}
 
-	sender *router.ServiceRouter
-	kind   patternKind    `yaml:"-"`
-	re     *regexp.Regexp `yaml:"-"`
-	gl     glob.Glob      `yaml:"-"`
+// path names the watcher in errors.
+func (w *WatcherConfig) path(i int) string {
+	if w.origin != "" {
+		return w.origin
+	}
+	return fmt.Sprintf("watcher[%d]", i)
 }
 
 func (w *WatcherConfig) Match(s string) bool {
@@ -81,12 +199,44 @@ func (w *WatcherConfig) Match(s string) bool {
 		return false
 	}
 }
-func (w *WatcherConfig) Notify(title string, message string) []error {
 
-	return w.sender.Send(message, &types.Params{
-		"title": title,
-	})
+// Notify renders the notification for every notifier of the watcher and
+// hands it to the outbox, subject to the notifier's rate limit and quiet hours.
+// Notifiers that don't subscribe to the severity of the match are skipped.
+func (w *WatcherConfig) Notify(throttle *Throttle, data *TemplateData) []error {
+	var errs []error
+	for i := range w.Notifiers {
+		if !w.Notifiers[i].Subscribes(data.Severity) {
+			continue
+		}
+		errs = append(errs, throttle.Deliver(&w.Notifiers[i], w, data))
+	}
+	return errs
+}
 
+// NotifyDigest sends each notifier the matches of the digest it subscribes to.
+func (w *WatcherConfig) NotifyDigest(throttle *Throttle, digest *DigestData) []error {
+	var errs []error
+	for i := range w.Notifiers {
+		n := &w.Notifiers[i]
+		filtered := digest
+		if n.severities != nil {
+			var matches []*TemplateData
+			for _, group := range digest.Groups {
+				for _, match := range group.Matches {
+					if n.Subscribes(match.Severity) {
+						matches = append(matches, match)
+					}
+				}
+			}
+			if len(matches) == 0 {
+				continue
+			}
+			filtered = NewDigestData(digest.Watcher, matches, digest.Start)
+		}
+		errs = append(errs, throttle.DeliverDigest(n, w, filtered))
+	}
+	return errs
 }
 
 func (c *Config) WatchersFor(s string) ([]WatcherConfig, bool) {
@@ -94,10 +244,8 @@ func (c *Config) WatchersFor(s string) ([]WatcherConfig, bool) {
 		return nil, false
 	}
 	var out []WatcherConfig
-	for _, watcher := range c.Watchers {
-		if watcher.Match(s) {
-			out = append(out, watcher)
-		}
+	for _, i := range c.matcher.Match(s) {
+		out = append(out, c.Watchers[i])
 	}
 	if len(out) == 0 {
 		return nil, false
@@ -105,82 +253,312 @@ func (c *Config) WatchersFor(s string) ([]WatcherConfig, bool) {
 	return out, true
 }
 
-func LoadConfigFile(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("read file: %w", err)
+// WatchersForCertificate returns every watcher whose name pattern matches the
+// common name or one of the DNS names, whose certificate conditions hold and
+// whose `when` expression evaluates to true. Watchers without a name pattern
+// skip the name check. Each watcher is returned at most once.
+func (c *Config) WatchersForCertificate(entry *CertificateEntry) []WatcherConfig {
+	cert := entry.Certificate
+
+	matched := make([]bool, len(c.Watchers))
+	markMatched := func(i int) { matched[i] = true }
+	c.matcher.Each(cert.Subject.CommonName, markMatched)
+	for _, name := range cert.DNSNames {
+		c.matcher.Each(name, markMatched)
+	}
+
+	var env *CertificateEnv
+	var out []WatcherConfig
+	for i, watcher := range c.Watchers {
+		if watcher.kind != pkNone && !matched[i] {
+			continue
+		}
+		if watcher.Certificate != nil && !watcher.Certificate.Match(cert) {
+			continue
+		}
+		if watcher.AllowedIssuers != nil && watcher.AllowedIssuers.Allows(entry) {
+			continue
+		}
+		if watcher.when != nil {
+			if env == nil {
+				e := NewCertificateEnv(entry)
+				env = &e
+			}
+			ok, err := evalExpression(watcher.when, env)
+			if err != nil {
+				logf("%s: failed to evaluate expression: %s\n", watcher.path(i), err.Error())
+				continue
+			}
+			if !ok {
+				continue
+			}
+		}
+		out = append(out, watcher)
 	}
-	return LoadConfig(b)
+	return out
 }
 
-func LoadConfig(yamlBytes []byte) (*Config, error) {
-	var cfg Config
-	if err := yaml.Unmarshal(yamlBytes, &cfg); err != nil {
+// LoadConfigFile loads the config file at path and the files it includes.
+func LoadConfigFile(path string) (*Config, error) {
+	return loadConfigFiles(path)
+}
+
+// LoadConfig parses and validates the configuration. References to
+// environment variables and files are interpolated. The contents of files
+// and the values of secret options (shoutrrrURL, postgresURL, url, secret,
+// headers and apiKeys), whether written in plain text or interpolated, are
+// treated as secrets and redacted in logs.
+// The extra watchers, e.g. those managed through the API, are added to the
+// watchers of the file without interpolation. All problems found are
+// reported, joined into one error.
+func LoadConfig(yamlBytes []byte, extra ...WatcherConfig) (*Config, error) {
+	return loadConfig(yamlBytes, nil, extra)
+}
+
+// loadConfig is LoadConfig with top-level notifiers of included files.
+func loadConfig(yamlBytes []byte, notifiers []NotifierConfig, extra []WatcherConfig) (*Config, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(yamlBytes, &document); err != nil {
 		return nil, fmt.Errorf("parse yaml: %w", err)
 	}
+	secrets, err := interpolateNode(&document)
+	registerSecrets(secrets)
+	if err != nil {
+		return nil, err
+	}
+	registerSecrets(collectSecrets(&document))
+	var cfg Config
+	if document.Kind != 0 {
+		if err := document.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("parse yaml: %w", redactError(err))
+		}
+	}
+	var errs []error
+	if err := cfg.prepareTenants(); err != nil {
+		errs = append(errs, err)
+	}
+	cfg.Watchers = append(cfg.Watchers, extra...)
+	cfg.Notifiers = append(cfg.Notifiers, notifiers...)
+	errs = append(errs, cfg.prepareNotifiers()...)
 
 	// Validate presence of either googleLogListURL or logsURLs.
 	if strings.TrimSpace(cfg.LogCollection.GoogleLogListURL) == "" && len(cfg.LogCollection.LogsURLs) == 0 {
-		return nil, fmt.Errorf("validation: either logCollection.googleLogListURL or logCollection.logsURLs must be provided")
+		errs = append(errs, fmt.Errorf("validation: either logCollection.googleLogListURL or logCollection.logsURLs must be provided"))
+	}
+
+	if err := cfg.Dispatch.validate(); err != nil {
+		errs = append(errs, err)
+	}
+
+	if cfg.CAA.Enabled {
+		checker, err := NewCAAChecker(cfg.CAA)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("caa: %w", err))
+		}
+		cfg.caa = checker
 	}
 
 	// Validate / prepare watchers.
 	if len(cfg.Watchers) == 0 {
-		return nil, fmt.Errorf("validation: at least one watcher must be provided")
+		errs = append(errs, fmt.Errorf("validation: at least one watcher must be provided"))
 	}
+	names := matcher.NewBuilder()
+	keys := map[string]int{}
 	for i := range cfg.Watchers {
+		errs = append(errs, cfg.prepareWatcher(i, names)...)
+
 		w := &cfg.Watchers[i]
+		keys[w.key]++
+		if n := keys[w.key]; n > 1 {
+			w.key = fmt.Sprintf("%s#%d", w.key, n)
+		}
+	}
+	if len(errs) > 0 {
+		// values may be quoted in errors, e.g. of invalid URLs
+		for i := range errs {
+			errs[i] = redactError(errs[i])
+		}
+		return nil, errors.Join(errs...)
+	}
+	cfg.matcher = names.Build()
 
-		hasRegex := strings.TrimSpace(w.RegexpRaw) != ""
-		hasQuery := strings.TrimSpace(w.Glob) != ""
+	return &cfg, nil
+}
 
-		switch {
-		case hasRegex && hasQuery:
-			return nil, fmt.Errorf("watcher[%d]: provide only one of 'regexp' or 'glob', not both", i)
+// prepareWatcher validates the watcher and compiles its patterns, expressions
+// and templates. The name pattern is added to names under the watcher's index.
+func (cfg *Config) prepareWatcher(i int, names *matcher.Builder) []error {
+	w := &cfg.Watchers[i]
+	w.index = i
+	var errs []error
 
-		case hasRegex:
-			re, err := regexp.Compile(w.RegexpRaw)
-			if err != nil {
-				return nil, fmt.Errorf("watcher[%d]: invalid regexp %q: %w", i, w.RegexpRaw, err)
-			}
-			w.kind = pkRegex
-			w.re = re
+	hasRegex := strings.TrimSpace(w.RegexpRaw) != ""
+	hasQuery := strings.TrimSpace(w.Glob) != ""
 
-		case hasQuery:
-			// Compile an efficient glob (wildcard) matcher.
-			// gobwas/glob matches the entire string by default (like ^...$).
-			g, err := glob.Compile(w.Glob)
-			if err != nil {
-				return nil, fmt.Errorf("watcher[%d]: invalid wildcard %q: %w", i, w.Glob, err)
-			}
-			w.kind = pkGlob
-			w.gl = g
+	switch {
+	case hasRegex && hasQuery:
+		errs = append(errs, fmt.Errorf("%s: provide only one of 'regexp' or 'glob', not both", w.path(i)))
 
-		default:
-			return nil, fmt.Errorf("watcher[%d]: must provide either 'query' (wildcard) or 'regexp'", i)
+	case hasRegex:
+		re, err := regexp.Compile(w.RegexpRaw)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid regexp %q: %w", w.path(i), w.RegexpRaw, err))
+			break
+		}
+		w.kind = pkRegex
+		w.re = re
+		if err := names.AddRegexp(i, w.RegexpRaw); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid regexp %q: %w", w.path(i), w.RegexpRaw, err))
 		}
 
-		if len(w.Notifiers) == 0 {
-			return nil, fmt.Errorf("watcher[%d]: at least one notifier is required", i)
+	case hasQuery:
+		// Compile an efficient glob (wildcard) matcher.
+		// gobwas/glob matches the entire string by default (like ^...$).
+		g, err := glob.Compile(w.Glob)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid wildcard %q: %w", w.path(i), w.Glob, err))
+			break
+		}
+		w.kind = pkGlob
+		w.gl = g
+		if err := names.AddGlob(i, w.Glob); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid wildcard %q: %w", w.path(i), w.Glob, err))
 		}
 
-		shoutrrrSenderURLs := []string{}
+	case w.Certificate != nil:
+		// certificate conditions only, checked once per certificate
 
-		for j, n := range w.Notifiers {
-			if strings.TrimSpace(n.ShoutrrrURL) == "" {
-				return nil, fmt.Errorf("watcher[%d].notifiers[%d]: shoutrrrURL is empty", i, j)
-			}
+	default:
+		errs = append(errs, fmt.Errorf("%s: must provide either 'glob' (wildcard), 'regexp' or 'certificate'", w.path(i)))
+	}
 
-			shoutrrrSenderURLs = append(shoutrrrSenderURLs, n.ShoutrrrURL)
+	if w.Certificate != nil {
+		if err := w.Certificate.compile(w.path(i) + ".certificate"); err != nil {
+			errs = append(errs, err)
+		}
+	}
 
+	if w.AllowedIssuers != nil {
+		if !hasRegex && !hasQuery {
+			errs = append(errs, fmt.Errorf("%s: allowedIssuers requires a 'glob' or 'regexp' for the domains to protect", w.path(i)))
+		} else if err := w.AllowedIssuers.compile(w.path(i) + ".allowedIssuers"); err != nil {
+			errs = append(errs, err)
 		}
+	}
 
-		shoutrrrSender, err := shoutrrr.NewSender(log.Default(), shoutrrrSenderURLs...)
+	if strings.TrimSpace(w.When) != "" {
+		program, err := compileExpression(w.path(i)+".when", w.When, CertificateEnv{})
 		if err != nil {
-			return nil, fmt.Errorf("watcher[%d]: unable to create sender: %s", i, err.Error())
+			errs = append(errs, err)
 		}
-		w.sender = shoutrrrSender
+		w.when = program
 	}
 
-	return &cfg, nil
+	if len(w.Notifiers) == 0 {
+		errs = append(errs, fmt.Errorf("%s: at least one notifier is required", w.path(i)))
+	}
+	if w.Tenant != "" && cfg.tenants[w.Tenant] == nil {
+		errs = append(errs, fmt.Errorf("%s: unknown tenant %q", w.path(i), w.Tenant))
+	}
+
+	if w.Digest != nil {
+		switch {
+		case w.Digest.Window.Duration < 0 || w.Digest.MaxCount < 0:
+			errs = append(errs, fmt.Errorf("%s.digest: window and maxCount can't be negative", w.path(i)))
+		case w.Digest.Window.Duration == 0:
+			// a digest that never reaches maxCount would never be sent otherwise
+			w.Digest.Window.Duration = defaultDigestWindow
+		}
+	}
+	if err := w.compileSeverity(w.path(i)); err != nil {
+		errs = append(errs, err)
+	}
+	// not the index, so that adding or removing other watchers doesn't
+	// change the identity; duplicates are numbered by loadConfig
+	w.key = fmt.Sprintf("%s|%s|%s|%s", w.Tenant, w.Name, w.Glob, w.RegexpRaw)
+
+	for j := range w.Notifiers {
+		n := &w.Notifiers[j]
+		path := fmt.Sprintf("%s.notifiers[%d]", w.path(i), j)
+		if n.Notifier != "" {
+			named, err := cfg.resolveNotifier(n, path, w.Tenant)
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if named == nil {
+				continue
+			}
+		} else if n.Name != "" {
+			errs = append(errs, fmt.Errorf("%s: only the notifiers of the config or of a tenant have a name, use 'notifier' to refer to one", path))
+			continue
+		}
+		n.tenant = w.Tenant
+		if err := cfg.prepareNotifier(w, n, path); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// prepareNotifier validates the notifier and parses its templates, falling
+// back to the templates of the watcher, the tenant and the config.
+func (cfg *Config) prepareNotifier(w *WatcherConfig, n *NotifierConfig, path string) error {
+	tenantTemplates := cfg.tenantTemplates(w)
+
+	hasShoutrrr := strings.TrimSpace(n.ShoutrrrURL) != ""
+	switch {
+	case hasShoutrrr && n.Webhook != nil:
+		return fmt.Errorf("%s: provide only one of 'shoutrrrURL' or 'webhook', not both", path)
+	case n.Webhook != nil:
+		if err := n.Webhook.validate(path + ".webhook"); err != nil {
+			return err
+		}
+	case !hasShoutrrr:
+		return fmt.Errorf("%s: shoutrrrURL is empty", path)
+	}
+
+	sample := sampleTemplateData(w)
+	title, err := parseTemplate(path+".template.title", firstNonEmpty(n.Template.Title, w.Template.Title, tenantTemplates.Title, cfg.Templates.Title, defaultTitleTemplate), sample)
+	if err != nil {
+		return err
+	}
+	message, err := parseTemplate(path+".template.message", firstNonEmpty(n.Template.Message, w.Template.Message, tenantTemplates.Message, cfg.Templates.Message, defaultMessageTemplate), sample)
+	if err != nil {
+		return err
+	}
+	n.title, n.message = title, message
+
+	if err := n.validateThrottle(path); err != nil {
+		return err
+	}
+	if err := n.compileSeverities(path); err != nil {
+		return err
+	}
+	if n.Workers < 0 {
+		return fmt.Errorf("%s.workers: can't be negative", path)
+	}
+
+	// suppressed matches may be folded into a digest even without a digest on the watcher
+	if w.Digest != nil || n.Suppressed == suppressDigest {
+		sampleDigest := sampleDigestData(w)
+		digestTitle, err := parseTemplate(path+".template.digestTitle", firstNonEmpty(n.Template.DigestTitle, w.Template.DigestTitle, tenantTemplates.DigestTitle, cfg.Templates.DigestTitle, defaultDigestTitleTemplate), sampleDigest)
+		if err != nil {
+			return err
+		}
+		digestMessage, err := parseTemplate(path+".template.digestMessage", firstNonEmpty(n.Template.DigestMessage, w.Template.DigestMessage, tenantTemplates.DigestMessage, cfg.Templates.DigestMessage, defaultDigestMessageTemplate), sampleDigest)
+		if err != nil {
+			return err
+		}
+		n.digestTitle, n.digestMessage = digestTitle, digestMessage
+	}
+
+	// named notifiers are only validated once
+	if n.Webhook != nil || n.Notifier != "" {
+		return nil
+	}
+	// the outbox creates the senders, this only validates the url
+	if _, err := shoutrrr.NewSender(log.Default(), n.ShoutrrrURL); err != nil {
+		return fmt.Errorf("%s: unable to create sender: %s", path, err.Error())
+	}
+	return nil
 }
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed web
+var webAssets embed.FS
+
+// dashboardHandler serves the dashboard, which only uses the JSON API.
+func dashboardHandler() http.Handler {
+	assets, err := fs.Sub(webAssets, "web")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServerFS(assets)
+}
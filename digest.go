@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultDigestTitleTemplate = `Certalert: {{.Count}} matching certificates{{with .Watcher.Name}} for {{.}}{{end}}`
+
+const defaultDigestMessageTemplate = `{{.Count}} matching certificates between {{.Start.Format "2006-01-02 15:04:05 MST"}} and {{.End.Format "2006-01-02 15:04:05 MST"}}
+{{- range .Groups}}
+
+{{defang .Domain}}, issued by {{.Issuer}} ({{len .Matches}}):
+{{- range .Matches}}
+  {{defang (join .MatchedNames ", ")}} (serial {{.Cert.Serial}}, {{.Log.Description}}{{if not $.Watcher.Name}}{{with .Watcher.Name}}, {{.}}{{end}}{{end}})
+{{- end}}
+{{- end}}`
+
+// defaultDigestWindow applies to digests that only set maxCount.
+const defaultDigestWindow = time.Hour
+
+type DigestConfig struct {
+	Window   Duration `yaml:"window"`   // send the digest at most this long after the first match, 1h by default
+	MaxCount int      `yaml:"maxCount"` // send the digest as soon as it holds this many matches
+
+	BypassSeverity string   `yaml:"bypassSeverity"` // matches of at least this severity are sent right away, critical by default
+	bypass         Severity `yaml:"-"`
+}
+
+// Bypasses reports whether a match of the severity skips the digest.
+func (d *DigestConfig) Bypasses(severity Severity) bool {
+	return severity.rank() >= d.bypass.rank()
+}
+
+// DigestGroup holds the matches of a digest for one registrable domain and issuer.
+type DigestGroup struct {
+	Domain  string
+	Issuer  string
+	Matches []*TemplateData
+}
+
+// DigestData is what digest templates are executed with.
+type DigestData struct {
+	Watcher *WatcherConfig
+	Count   int
+	Start   time.Time
+	End     time.Time
+	Groups  []*DigestGroup
+}
+
+func issuerDisplayName(data *TemplateData) string {
+	organization := strings.Join(data.Certificate.Issuer.Organization, ", ")
+	switch {
+	case organization == "":
+		return data.Certificate.Issuer.CommonName
+	case data.Certificate.Issuer.CommonName == "":
+		return organization
+	default:
+		return fmt.Sprintf("%s (%s)", organization, data.Certificate.Issuer.CommonName)
+	}
+}
+
+func NewDigestData(watcher *WatcherConfig, matches []*TemplateData, start time.Time) *DigestData {
+	digest := &DigestData{
+		Watcher: watcher,
+		Count:   len(matches),
+		Start:   start,
+		End:     time.Now(),
+	}
+
+	groups := map[string]*DigestGroup{}
+	for _, match := range matches {
+		names := match.MatchedNames
+		if len(names) == 0 {
+			names = match.Cert.Names
+		}
+		domain := ""
+		if len(names) > 0 {
+			domain = getBaseDomain(strings.TrimPrefix(names[0], "*."))
+		}
+		issuer := issuerDisplayName(match)
+
+		key := domain + "\x00" + issuer
+		group, ok := groups[key]
+		if !ok {
+			group = &DigestGroup{Domain: domain, Issuer: issuer}
+			groups[key] = group
+			digest.Groups = append(digest.Groups, group)
+		}
+		group.Matches = append(group.Matches, match)
+	}
+
+	sort.SliceStable(digest.Groups, func(i, j int) bool {
+		if digest.Groups[i].Domain != digest.Groups[j].Domain {
+			return digest.Groups[i].Domain < digest.Groups[j].Domain
+		}
+		return digest.Groups[i].Issuer < digest.Groups[j].Issuer
+	})
+
+	return digest
+}
+
+type pendingDigest struct {
+	watcher WatcherConfig
+	matches []*TemplateData
+	start   time.Time
+	timer   *time.Timer
+}
+
+// Digester collects matches per watcher and sends them as one notification
+// once the watcher's window has passed or enough matches were collected.
+type Digester struct {
+	throttle *Throttle
+	mutex    sync.Mutex
+	pending  map[string]*pendingDigest
+}
+
+func NewDigester(throttle *Throttle) *Digester {
+	return &Digester{throttle: throttle, pending: map[string]*pendingDigest{}}
+}
+
+func (d *Digester) Add(watcher WatcherConfig, data *TemplateData) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	p, ok := d.pending[watcher.key]
+	if !ok {
+		p = &pendingDigest{start: time.Now()}
+		d.pending[watcher.key] = p
+		if watcher.Digest.Window.Duration > 0 {
+			key := watcher.key
+			p.timer = time.AfterFunc(watcher.Digest.Window.Duration, func() {
+				d.flush(key)
+			})
+		}
+	}
+	// always send with the most recently loaded configuration of the watcher
+	p.watcher = watcher
+	data.Watcher = &p.watcher
+	p.matches = append(p.matches, data)
+
+	if watcher.Digest.MaxCount > 0 && len(p.matches) >= watcher.Digest.MaxCount {
+		d.sendLocked(watcher.key)
+	}
+}
+
+func (d *Digester) flush(key string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.sendLocked(key)
+}
+
+// FlushAll sends every pending digest right away.
+func (d *Digester) FlushAll() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for key := range d.pending {
+		d.sendLocked(key)
+	}
+}
+
+func (d *Digester) Pending() int {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	count := 0
+	for _, p := range d.pending {
+		count += len(p.matches)
+	}
+	return count
+}
+
+func (d *Digester) sendLocked(key string) {
+	p, ok := d.pending[key]
+	if !ok {
+		return
+	}
+	delete(d.pending, key)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+
+	digest := NewDigestData(&p.watcher, p.matches, p.start)
+	for _, err := range p.watcher.NotifyDigest(d.throttle, digest) {
+		if err == nil {
+			continue
+		}
+		logln("failed to send digest", err.Error())
+	}
+}
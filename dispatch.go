@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+type DispatchConfig struct {
+	BufferSize int    `yaml:"bufferSize"` // matched certificates waiting to be dispatched, 1000 by default
+	Workers    int    `yaml:"workers"`    // goroutines preparing notifications, 4 by default
+	WhenFull   string `yaml:"whenFull"`   // block (default) or drop
+}
+
+const (
+	whenFullBlock = "block"
+	whenFullDrop  = "drop"
+)
+
+func (c *DispatchConfig) validate() error {
+	if c.BufferSize < 0 || c.Workers < 0 {
+		return fmt.Errorf("dispatch: bufferSize and workers can't be negative")
+	}
+	switch c.WhenFull {
+	case "", whenFullBlock, whenFullDrop:
+	default:
+		return fmt.Errorf("dispatch.whenFull: unknown policy %q (expected block or drop)", c.WhenFull)
+	}
+	return nil
+}
+
+// Dispatcher decouples the log goroutines from notifying. Matched certificates
+// are buffered and handled by a pool of workers, so a slow CAA lookup or
+// notification backend doesn't stall log ingestion until the buffer is full.
+type Dispatcher struct {
+	config DispatchConfig
+	queue  chan NotifyInstruction
+	handle func(NotifyInstruction)
+	wg     sync.WaitGroup
+}
+
+func NewDispatcher(c DispatchConfig, handle func(NotifyInstruction)) *Dispatcher {
+	if c.BufferSize == 0 {
+		c.BufferSize = 1000
+	}
+	if c.Workers == 0 {
+		c.Workers = 4
+	}
+	if c.WhenFull == "" {
+		c.WhenFull = whenFullBlock
+	}
+
+	d := &Dispatcher{
+		config: c,
+		queue:  make(chan NotifyInstruction, c.BufferSize),
+		handle: handle,
+	}
+	for i := 0; i < c.Workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	return d
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for instruction := range d.queue {
+		d.handle(instruction)
+	}
+}
+
+// Submit hands a matched certificate to the workers. If the buffer is full it
+// either waits for space or drops the certificate, depending on whenFull.
+func (d *Dispatcher) Submit(instruction NotifyInstruction) bool {
+	select {
+	case d.queue <- instruction:
+		return true
+	default:
+	}
+
+	if d.config.WhenFull == whenFullDrop {
+		prometheusDispatchDropped.Inc()
+		logln("dispatch buffer is full, dropping match for", instruction.Certificate.Subject.CommonName)
+		return false
+	}
+
+	start := time.Now()
+	d.queue <- instruction
+	prometheusDispatchBlocked.Add(time.Since(start).Seconds())
+	return true
+}
+
+func (d *Dispatcher) Len() int {
+	return len(d.queue)
+}
+
+func (d *Dispatcher) Cap() int {
+	return cap(d.queue)
+}
+
+// Drain stops accepting matches and waits until the workers handled the
+// buffered ones. Submit must not be called anymore.
+func (d *Dispatcher) Drain(ctx context.Context) error {
+	close(d.queue)
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d matches were not dispatched: %w", len(d.queue), ctx.Err())
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// CertificateEnv is the object a watcher's `when` expression is evaluated
+// against. The expr tags are the names available inside the expression.
+type CertificateEnv struct {
+	Names          []string `expr:"names"` // common name and DNS names, without duplicates
+	CommonName     string   `expr:"commonName"`
+	DNSNames       []string `expr:"dnsNames"`
+	IPAddresses    []string `expr:"ipAddresses"`
+	EmailAddresses []string `expr:"emailAddresses"`
+	URIs           []string `expr:"uris"`
+
+	Subject                   string   `expr:"subject"`
+	SubjectOrganization       []string `expr:"subjectOrganization"`
+	SubjectOrganizationalUnit []string `expr:"subjectOrganizationalUnit"`
+	SubjectCountry            []string `expr:"subjectCountry"`
+
+	Issuer             string   `expr:"issuer"`
+	IssuerCommonName   string   `expr:"issuerCommonName"`
+	IssuerOrganization []string `expr:"issuerOrganization"`
+
+	Serial          string        `expr:"serial"` // upper case hex
+	NotBefore       time.Time     `expr:"notBefore"`
+	NotAfter        time.Time     `expr:"notAfter"`
+	Validity        time.Duration `expr:"validity"`
+	KeyType         string        `expr:"keyType"` // rsa, ecdsa or ed25519
+	KeySize         int           `expr:"keySize"`
+	ValidationLevel string        `expr:"validationLevel"` // dv, ov, iv, ev or empty
+	PolicyOIDs      []string      `expr:"policyOIDs"`
+	Precert         bool          `expr:"precert"`
+
+	LogOperator    string `expr:"logOperator"`
+	LogDescription string `expr:"logDescription"`
+	LogURL         string `expr:"logURL"`
+	LogIndex       int64  `expr:"logIndex"`
+}
+
+func certificateNames(cert *x509.Certificate) []string {
+	names := make([]string, 0, len(cert.DNSNames)+1)
+	seen := make(map[string]bool, len(cert.DNSNames)+1)
+	for _, name := range append([]string{cert.Subject.CommonName}, cert.DNSNames...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+func NewCertificateEnv(entry *CertificateEntry) CertificateEnv {
+	cert := entry.Certificate
+
+	keyType, keySize := certificateKeyInfo(cert)
+
+	env := CertificateEnv{
+		Names:          certificateNames(cert),
+		CommonName:     cert.Subject.CommonName,
+		DNSNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+
+		Subject:                   cert.Subject.String(),
+		SubjectOrganization:       cert.Subject.Organization,
+		SubjectOrganizationalUnit: cert.Subject.OrganizationalUnit,
+		SubjectCountry:            cert.Subject.Country,
+
+		Issuer:             cert.Issuer.String(),
+		IssuerCommonName:   cert.Issuer.CommonName,
+		IssuerOrganization: cert.Issuer.Organization,
+
+		NotBefore:       cert.NotBefore,
+		NotAfter:        cert.NotAfter,
+		Validity:        cert.NotAfter.Sub(cert.NotBefore),
+		KeyType:         keyType,
+		KeySize:         keySize,
+		ValidationLevel: certificateValidationLevel(cert),
+		Precert:         entry.Precert,
+
+		LogOperator:    entry.Log.OperatorName,
+		LogDescription: entry.Log.Description,
+		LogURL:         entry.Log.Url,
+		LogIndex:       entry.Index,
+	}
+
+	if cert.SerialNumber != nil {
+		env.Serial = fmt.Sprintf("%X", cert.SerialNumber)
+	}
+	for _, ip := range cert.IPAddresses {
+		env.IPAddresses = append(env.IPAddresses, ip.String())
+	}
+	for _, u := range cert.URIs {
+		env.URIs = append(env.URIs, u.String())
+	}
+	for _, oid := range cert.PolicyIdentifiers {
+		env.PolicyOIDs = append(env.PolicyOIDs, oid.String())
+	}
+
+	return env
+}
+
+// compileExpression compiles a boolean expression over env, usually a
+// CertificateEnv. Expressions can only read the certificate; there are no
+// functions with side effects available.
+func compileExpression(path string, source string, env any) (*vm.Program, error) {
+	program, err := expr.Compile(source, expr.Env(env), expr.AsBool())
+	if err != nil {
+		// expr errors span multiple lines (source and a caret), indent them
+		// below the path so they stay readable
+		return nil, fmt.Errorf("%s: invalid expression:\n\t%s", path, strings.ReplaceAll(err.Error(), "\n", "\n\t"))
+	}
+	return program, nil
+}
+
+func evalExpression(program *vm.Program, env any) (bool, error) {
+	out, err := expr.Run(program, env)
+	if err != nil {
+		return false, err
+	}
+	return out.(bool), nil
+}
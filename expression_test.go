@@ -0,0 +1,70 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWhenExpressions(t *testing.T) {
+	entry := fieldsEntry(t)
+	entry.Precert = true
+	entry.Index = 42
+	entry.Log = CtLogUpdateLog{OperatorName: "Example", Description: "Example log", Url: "https://ct.example.com/"}
+	// evaluated like the watchers do, against a pointer
+	env := NewCertificateEnv(entry)
+
+	tests := []struct {
+		when string
+		want bool
+	}{
+		{when: `keyType == "ecdsa" && keySize >= 256`, want: true},
+		{when: `keyType == "rsa" || keySize > 256`},
+		{when: `validity > duration("2000h") && validity < duration("2200h")`, want: true},
+		{when: `any(names, # endsWith ".example.com")`, want: true},
+		{when: `all(dnsNames, # endsWith ".example.com")`},
+		{when: `len(names) == 2 && commonName == names[0]`, want: true},
+		{when: `"CH" in subjectCountry && "Security" in subjectOrganizationalUnit`, want: true},
+		{when: `issuerOrganization[0] matches "^Example "`, want: true},
+		{when: `issuerCommonName startsWith "Example" && issuer contains "O=Example Trust Services"`, want: true},
+		{when: `validationLevel == "ov" && "2.23.140.1.2.2" in policyOIDs`, want: true},
+		{when: `validationLevel in ["dv", "ev"]`},
+		{when: `serial == "C0FFEE"`, want: true},
+		{when: `"192.0.2.10" in ipAddresses && "security@example.com" in emailAddresses`, want: true},
+		{when: `uris[0] == "https://id.example.com/device"`, want: true},
+		{when: `notAfter.Sub(notBefore) == validity`, want: true},
+		{when: `precert && logOperator == "Example" && logIndex == 42 && logURL == "https://ct.example.com/"`, want: true},
+		{when: `not precert`},
+	}
+	for _, test := range tests {
+		t.Run(test.when, func(t *testing.T) {
+			program, err := compileExpression("when", test.when, CertificateEnv{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := evalExpression(program, &env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Errorf("evalExpression() = %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWhenExpressionErrors(t *testing.T) {
+	for _, when := range []string{
+		`keySize + 1`,          // not a boolean
+		`unknownField > 1`,     // not in the environment
+		`keySize == "256"`,     // mismatched types
+		`names ==`,             // syntax
+		`len(names) > 1 &&`,    // syntax
+		`KeySize > 1`,          // the Go name, not the expr tag
+		`keyType.Unknown == 1`, // strings have no fields
+	} {
+		_, err := compileExpression("watchers[0].when", when, CertificateEnv{})
+		if err == nil || !strings.HasPrefix(err.Error(), "watchers[0].when: invalid expression:") {
+			t.Errorf("compileExpression(%q) = %v, want an invalid expression error", when, err)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// CA/Browser Forum policy identifiers used to derive the validation level.
+var validationLevelOIDs = map[string]string{
+	"2.23.140.1.1":   "ev",
+	"2.23.140.1.2.1": "dv",
+	"2.23.140.1.2.2": "ov",
+	"2.23.140.1.2.3": "iv",
+}
+
+// CertificateMatcherConfig matches certificate fields other than the names.
+// Every field that is set has to match; list fields match if any of their
+// patterns matches any of the certificate's values. All, Any and Not combine
+// nested matchers.
+type CertificateMatcherConfig struct {
+	All []CertificateMatcherConfig `yaml:"all"`
+	Any []CertificateMatcherConfig `yaml:"any"`
+	Not *CertificateMatcherConfig  `yaml:"not"`
+
+	Issuer                    []string `yaml:"issuer"` // globs against the full issuer DN
+	IssuerCommonName          []string `yaml:"issuerCommonName"`
+	IssuerOrganization        []string `yaml:"issuerOrganization"`
+	SubjectOrganization       []string `yaml:"subjectOrganization"`
+	SubjectOrganizationalUnit []string `yaml:"subjectOrganizationalUnit"`
+	SubjectCountry            []string `yaml:"subjectCountry"`
+	IPAddresses               []string `yaml:"ipAddresses"` // IPs or CIDR ranges
+	EmailAddresses            []string `yaml:"emailAddresses"`
+	URIs                      []string `yaml:"uris"`
+	KeyAlgorithm              []string `yaml:"keyAlgorithm"` // rsa, ecdsa or ed25519
+	MinKeySize                int      `yaml:"minKeySize"`
+	MaxKeySize                int      `yaml:"maxKeySize"`
+	MinValidity               Duration `yaml:"minValidity"`
+	MaxValidity               Duration `yaml:"maxValidity"`
+	ValidationLevel           []string `yaml:"validationLevel"` // dv, ov, iv or ev
+	PolicyOIDs                []string `yaml:"policyOIDs"`
+
+	issuer              []glob.Glob  `yaml:"-"`
+	issuerCommonName    []glob.Glob  `yaml:"-"`
+	issuerOrganization  []glob.Glob  `yaml:"-"`
+	subjectOrganization []glob.Glob  `yaml:"-"`
+	subjectOrgUnit      []glob.Glob  `yaml:"-"`
+	subjectCountry      []glob.Glob  `yaml:"-"`
+	ipNets              []*net.IPNet `yaml:"-"`
+	emailAddresses      []glob.Glob  `yaml:"-"`
+	uris                []glob.Glob  `yaml:"-"`
+}
+
+// compileGlobs compiles case-insensitive field patterns.
+func compileGlobs(path string, patterns []string) ([]glob.Glob, error) {
+	out := make([]glob.Glob, 0, len(patterns))
+	for _, p := range patterns {
+		g, err := glob.Compile(strings.ToLower(p))
+		if err != nil {
+			return nil, fmt.Errorf("%s: invalid wildcard %q: %w", path, p, err)
+		}
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+func (m *CertificateMatcherConfig) compile(path string) error {
+	var err error
+
+	globFields := []struct {
+		name     string
+		patterns []string
+		dst      *[]glob.Glob
+	}{
+		{"issuer", m.Issuer, &m.issuer},
+		{"issuerCommonName", m.IssuerCommonName, &m.issuerCommonName},
+		{"issuerOrganization", m.IssuerOrganization, &m.issuerOrganization},
+		{"subjectOrganization", m.SubjectOrganization, &m.subjectOrganization},
+		{"subjectOrganizationalUnit", m.SubjectOrganizationalUnit, &m.subjectOrgUnit},
+		{"subjectCountry", m.SubjectCountry, &m.subjectCountry},
+		{"emailAddresses", m.EmailAddresses, &m.emailAddresses},
+		{"uris", m.URIs, &m.uris},
+	}
+	for _, f := range globFields {
+		if *f.dst, err = compileGlobs(path+"."+f.name, f.patterns); err != nil {
+			return err
+		}
+	}
+
+	m.ipNets = nil
+	for _, raw := range m.IPAddresses {
+		if !strings.Contains(raw, "/") {
+			ip := net.ParseIP(raw)
+			if ip == nil {
+				return fmt.Errorf("%s.ipAddresses: invalid ip %q", path, raw)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			m.ipNets = append(m.ipNets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(raw)
+		if err != nil {
+			return fmt.Errorf("%s.ipAddresses: invalid cidr %q: %w", path, raw, err)
+		}
+		m.ipNets = append(m.ipNets, ipNet)
+	}
+
+	for _, alg := range m.KeyAlgorithm {
+		switch strings.ToLower(alg) {
+		case "rsa", "ecdsa", "ed25519":
+		default:
+			return fmt.Errorf("%s.keyAlgorithm: unknown algorithm %q (expected rsa, ecdsa or ed25519)", path, alg)
+		}
+	}
+
+	for _, level := range m.ValidationLevel {
+		switch strings.ToLower(level) {
+		case "dv", "ov", "iv", "ev":
+		default:
+			return fmt.Errorf("%s.validationLevel: unknown level %q (expected dv, ov, iv or ev)", path, level)
+		}
+	}
+
+	if m.MaxKeySize > 0 && m.MinKeySize > m.MaxKeySize {
+		return fmt.Errorf("%s: minKeySize is larger than maxKeySize", path)
+	}
+	if m.MaxValidity.Duration > 0 && m.MinValidity.Duration > m.MaxValidity.Duration {
+		return fmt.Errorf("%s: minValidity is longer than maxValidity", path)
+	}
+
+	for i := range m.All {
+		if err := m.All[i].compile(fmt.Sprintf("%s.all[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i := range m.Any {
+		if err := m.Any[i].compile(fmt.Sprintf("%s.any[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if m.Not != nil {
+		if err := m.Not.compile(path + ".not"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func matchAnyGlob(globs []glob.Glob, values []string) bool {
+	for _, v := range values {
+		v = strings.ToLower(v)
+		for _, g := range globs {
+			if g.Match(v) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func certificateKeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "rsa", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ecdsa", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "ed25519", 256
+	default:
+		return strings.ToLower(cert.PublicKeyAlgorithm.String()), 0
+	}
+}
+
+func certificateValidationLevel(cert *x509.Certificate) string {
+	for _, oid := range cert.PolicyIdentifiers {
+		if level, ok := validationLevelOIDs[oid.String()]; ok {
+			return level
+		}
+	}
+	return ""
+}
+
+// Match reports whether the certificate satisfies the matcher. A matcher
+// without any conditions matches every certificate.
+func (m *CertificateMatcherConfig) Match(cert *x509.Certificate) bool {
+	if len(m.issuer) > 0 && !matchAnyGlob(m.issuer, []string{cert.Issuer.String()}) {
+		return false
+	}
+	if len(m.issuerCommonName) > 0 && !matchAnyGlob(m.issuerCommonName, []string{cert.Issuer.CommonName}) {
+		return false
+	}
+	if len(m.issuerOrganization) > 0 && !matchAnyGlob(m.issuerOrganization, cert.Issuer.Organization) {
+		return false
+	}
+	if len(m.subjectOrganization) > 0 && !matchAnyGlob(m.subjectOrganization, cert.Subject.Organization) {
+		return false
+	}
+	if len(m.subjectOrgUnit) > 0 && !matchAnyGlob(m.subjectOrgUnit, cert.Subject.OrganizationalUnit) {
+		return false
+	}
+	if len(m.subjectCountry) > 0 && !matchAnyGlob(m.subjectCountry, cert.Subject.Country) {
+		return false
+	}
+	if len(m.emailAddresses) > 0 && !matchAnyGlob(m.emailAddresses, cert.EmailAddresses) {
+		return false
+	}
+	if len(m.uris) > 0 {
+		uris := make([]string, 0, len(cert.URIs))
+		for _, u := range cert.URIs {
+			uris = append(uris, u.String())
+		}
+		if !matchAnyGlob(m.uris, uris) {
+			return false
+		}
+	}
+
+	if len(m.ipNets) > 0 {
+		found := false
+		for _, ip := range cert.IPAddresses {
+			for _, ipNet := range m.ipNets {
+				if ipNet.Contains(ip) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(m.KeyAlgorithm) > 0 || m.MinKeySize > 0 || m.MaxKeySize > 0 {
+		alg, size := certificateKeyInfo(cert)
+		if len(m.KeyAlgorithm) > 0 && !containsFold(m.KeyAlgorithm, alg) {
+			return false
+		}
+		if m.MinKeySize > 0 && size < m.MinKeySize {
+			return false
+		}
+		if m.MaxKeySize > 0 && size > m.MaxKeySize {
+			return false
+		}
+	}
+
+	validity := cert.NotAfter.Sub(cert.NotBefore)
+	if m.MinValidity.Duration > 0 && validity < m.MinValidity.Duration {
+		return false
+	}
+	if m.MaxValidity.Duration > 0 && validity > m.MaxValidity.Duration {
+		return false
+	}
+
+	if len(m.ValidationLevel) > 0 && !containsFold(m.ValidationLevel, certificateValidationLevel(cert)) {
+		return false
+	}
+
+	if len(m.PolicyOIDs) > 0 {
+		found := false
+		for _, oid := range cert.PolicyIdentifiers {
+			if containsFold(m.PolicyOIDs, oid.String()) {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for i := range m.All {
+		if !m.All[i].Match(cert) {
+			return false
+		}
+	}
+
+	if len(m.Any) > 0 {
+		found := false
+		for i := range m.Any {
+			if m.Any[i].Match(cert) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if m.Not != nil && m.Not.Match(cert) {
+		return false
+	}
+
+	return true
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
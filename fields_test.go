@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// fieldsEntry returns an OV certificate with an ECDSA P-256 key, valid for
+// 90 days, with every field the certificate matchers look at.
+func fieldsEntry(t *testing.T) *CertificateEntry {
+	t.Helper()
+	uri, err := url.Parse("https://id.example.com/device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	entry, _ := issueCertificate(t, pkix.Name{CommonName: "Example Issuing CA", Organization: []string{"Example Trust Services"}}, &x509.Certificate{
+		SerialNumber: big.NewInt(0xc0ffee),
+		Subject: pkix.Name{
+			CommonName:         "www.example.com",
+			Organization:       []string{"Example Corp"},
+			OrganizationalUnit: []string{"Security"},
+			Country:            []string{"CH"},
+		},
+		DNSNames:          []string{"www.example.com", "example.com"},
+		IPAddresses:       []net.IP{net.ParseIP("192.0.2.10")},
+		EmailAddresses:    []string{"security@example.com"},
+		URIs:              []*url.URL{uri},
+		PolicyIdentifiers: []asn1.ObjectIdentifier{{2, 23, 140, 1, 2, 2}},
+		NotBefore:         notBefore,
+		NotAfter:          notBefore.Add(90 * 24 * time.Hour),
+	})
+	return entry
+}
+
+func TestCertificateMatcher(t *testing.T) {
+	cert := fieldsEntry(t).Certificate
+
+	tests := []struct {
+		name    string
+		matcher string
+		matches bool
+	}{
+		{name: "no conditions", matcher: `{}`, matches: true},
+		{name: "issuer organization, case-insensitive", matcher: `issuerOrganization: ["example TRUST *"]`, matches: true},
+		{name: "issuer common name", matcher: `issuerCommonName: ["*Root*"]`},
+		{name: "full issuer DN", matcher: `issuer: ["cn=example issuing ca,o=*"]`, matches: true},
+		{name: "one of several patterns", matcher: `subjectCountry: [de, ch]`, matches: true},
+		{name: "subject organizational unit", matcher: `subjectOrganizationalUnit: [marketing]`},
+		{name: "every field has to match", matcher: `{subjectOrganization: ["example*"], subjectCountry: [de]}`},
+		{name: "ip in range", matcher: `ipAddresses: [192.0.2.0/24]`, matches: true},
+		{name: "ip not in list", matcher: `ipAddresses: [198.51.100.1, "2001:db8::/32"]`},
+		{name: "email address", matcher: `emailAddresses: ["*@EXAMPLE.com"]`, matches: true},
+		{name: "uri", matcher: `uris: ["https://*.example.com/*"]`, matches: true},
+		{name: "key algorithm and size", matcher: `{keyAlgorithm: [ECDSA], minKeySize: 256, maxKeySize: 384}`, matches: true},
+		{name: "other key algorithm", matcher: `keyAlgorithm: [rsa]`},
+		{name: "key too small", matcher: `minKeySize: 384`},
+		{name: "validity", matcher: `{minValidity: 720h, maxValidity: 2400h}`, matches: true},
+		{name: "validity too long", matcher: `maxValidity: 720h`},
+		{name: "validation level", matcher: `validationLevel: [OV, ev]`, matches: true},
+		{name: "other validation level", matcher: `validationLevel: [dv]`},
+		{name: "policy", matcher: `policyOIDs: [2.23.140.1.2.2]`, matches: true},
+		{name: "all", matcher: `all: [{subjectCountry: [ch]}, {keyAlgorithm: [ecdsa]}]`, matches: true},
+		{name: "all with one mismatch", matcher: `all: [{subjectCountry: [ch]}, {keyAlgorithm: [rsa]}]`},
+		{name: "any", matcher: `any: [{subjectCountry: [de]}, {keyAlgorithm: [ecdsa]}]`, matches: true},
+		{name: "any without a match", matcher: `any: [{subjectCountry: [de]}, {keyAlgorithm: [rsa]}]`},
+		{name: "not", matcher: `not: {subjectCountry: [ch]}`},
+		{name: "not of a mismatch", matcher: `not: {subjectCountry: [de]}`, matches: true},
+		{name: "not with fields", matcher: `{issuerOrganization: ["example*"], not: {validationLevel: [dv]}}`, matches: true},
+		{
+			name: "nested",
+			matcher: `
+any:
+  - not: {keyAlgorithm: [ecdsa]}
+  - all:
+      - uris: ["https://id.example.com/*"]
+      - any: [{emailAddresses: ["*@example.org"]}, {not: {subjectCountry: [us]}}]
+`,
+			matches: true,
+		},
+		{
+			name: "nested mismatch",
+			matcher: `
+any:
+  - not: {keyAlgorithm: [ecdsa]}
+  - all:
+      - uris: ["https://id.example.com/*"]
+      - not: {any: [{emailAddresses: ["*@example.org"]}, {subjectCountry: [ch]}]}
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var m CertificateMatcherConfig
+			if err := yaml.Unmarshal([]byte(test.matcher), &m); err != nil {
+				t.Fatal(err)
+			}
+			if err := m.compile("certificate"); err != nil {
+				t.Fatal(err)
+			}
+			if got := m.Match(cert); got != test.matches {
+				t.Errorf("Match() = %t, want %t", got, test.matches)
+			}
+		})
+	}
+}
+
+func TestCertificateMatcherCompileErrors(t *testing.T) {
+	tests := []struct {
+		matcher string
+		err     string // the start of the error
+	}{
+		{matcher: `issuer: ["[a-"]`, err: "certificate.issuer: invalid wildcard"},
+		{matcher: `ipAddresses: [192.0.2.300]`, err: "certificate.ipAddresses: invalid ip"},
+		{matcher: `ipAddresses: [192.0.2.0/33]`, err: "certificate.ipAddresses: invalid cidr"},
+		{matcher: `keyAlgorithm: [dsa]`, err: "certificate.keyAlgorithm: unknown algorithm"},
+		{matcher: `validationLevel: [qv]`, err: "certificate.validationLevel: unknown level"},
+		{matcher: `{minKeySize: 4096, maxKeySize: 2048}`, err: "certificate: minKeySize is larger"},
+		{matcher: `{minValidity: 48h, maxValidity: 24h}`, err: "certificate: minValidity is longer"},
+		{matcher: `all: [{}, {subjectCountry: ["[a-"]}]`, err: "certificate.all[1].subjectCountry: invalid wildcard"},
+		{matcher: `any: [{not: {keyAlgorithm: [dsa]}}]`, err: "certificate.any[0].not.keyAlgorithm: unknown algorithm"},
+	}
+	for _, test := range tests {
+		var m CertificateMatcherConfig
+		if err := yaml.Unmarshal([]byte(test.matcher), &m); err != nil {
+			t.Fatal(err)
+		}
+		err := m.compile("certificate")
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%s: compile() = %v, want an error starting with %q", test.matcher, err, test.err)
+		}
+	}
+}
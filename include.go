@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the YAML files in this directory next to the config file are included
+const watchersDir = "watchers.d"
+
+// includeFile is the content of an included file.
+type includeFile struct {
+	Watchers  []WatcherConfig  `yaml:"watchers"`
+	Notifiers []NotifierConfig `yaml:"notifiers"`
+}
+
+// includedFiles returns the files included by the config file at path: the
+// matches of its include patterns, relative to the config file, and the YAML
+// files of watchers.d. Each file is returned once, in a stable order.
+func includedFiles(path string, patterns []string) ([]string, error) {
+	dir := filepath.Dir(path)
+	self, _ := filepath.Abs(path)
+	seen := map[string]bool{self: true}
+	var files []string
+	var errs []error
+
+	add := func(pattern string) ([]string, error) {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			abs, _ := filepath.Abs(match)
+			if info, err := os.Stat(match); seen[abs] || (err == nil && info.IsDir()) {
+				continue
+			}
+			seen[abs] = true
+			files = append(files, match)
+		}
+		return matches, nil
+	}
+
+	for i, pattern := range patterns {
+		matches, err := add(pattern)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("include[%d]: %w", i, err))
+		// a pattern without wildcards names a single file that must exist
+		case len(matches) == 0 && !strings.ContainsAny(pattern, `*?[\`):
+			errs = append(errs, fmt.Errorf("include[%d]: %s doesn't exist", i, pattern))
+		}
+	}
+	add(filepath.Join(watchersDir, "*.yml"))
+	add(filepath.Join(watchersDir, "*.yaml"))
+	return files, errors.Join(errs...)
+}
+
+// loadIncludes reads the watchers and notifiers of the files included by the
+// config file. They are named after their file in errors, e.g.
+// watchers.d/team.yml: watcher[0]. If the config file itself can't be
+// parsed, nothing is included; loadConfig reports that error.
+func loadIncludes(path string, yamlBytes []byte) (*includeFile, error) {
+	var config struct {
+		Include []string `yaml:"include"`
+	}
+	if err := yaml.Unmarshal(yamlBytes, &config); err != nil {
+		return &includeFile{}, nil
+	}
+	files, err := includedFiles(path, config.Include)
+	errs := []error{err}
+
+	all := &includeFile{}
+	for _, file := range files {
+		name, err := filepath.Rel(filepath.Dir(path), file)
+		if err != nil {
+			name = file
+		}
+		included, err := loadIncludeFile(file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		for j := range included.Watchers {
+			included.Watchers[j].origin = fmt.Sprintf("%s: watcher[%d]", name, j)
+		}
+		for j := range included.Notifiers {
+			included.Notifiers[j].source = name
+		}
+		all.Watchers = append(all.Watchers, included.Watchers...)
+		all.Notifiers = append(all.Notifiers, included.Notifiers...)
+	}
+	return all, errors.Join(errs...)
+}
+
+// loadConfigFiles loads the config file at path together with the files it
+// includes. Problems of the included files are reported along with the others.
+func loadConfigFiles(path string, extra ...WatcherConfig) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	included, includeErr := loadIncludes(path, b)
+	config, err := loadConfig(b, included.Notifiers, append(included.Watchers, extra...))
+	if includeErr != nil {
+		return nil, errors.Join(includeErr, err)
+	}
+	return config, err
+}
+
+func loadIncludeFile(file string) (*includeFile, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var document yaml.Node
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", err)
+	}
+	secrets, err := interpolateNode(&document)
+	registerSecrets(secrets)
+	if err != nil {
+		return nil, err
+	}
+	registerSecrets(collectSecrets(&document))
+
+	// only watchers and notifiers can be included, other keys are most likely mistakes
+	var included includeFile
+	if document.Kind == 0 {
+		return &included, nil
+	}
+	if root := document.Content[0]; root.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if key := root.Content[i]; key.Value != "watchers" && key.Value != "notifiers" {
+				return nil, fmt.Errorf("line %d: %q can't be included, only watchers and notifiers", key.Line, key.Value)
+			}
+		}
+	}
+	if err := document.Decode(&included); err != nil {
+		return nil, fmt.Errorf("parse yaml: %w", redactError(err))
+	}
+	return &included, nil
+}
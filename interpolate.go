@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ${NAME} is replaced with an environment variable, ${file:/path} with the
+// contents of a file, e.g. a Docker or Kubernetes secret. $${ is a literal ${.
+var interpolation = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// interpolateNode replaces the references in all scalar values of the
+// document and returns the secrets that were inserted: the values of files
+// and of anything inserted into a secret option, such as a notifier URL, a
+// webhook header or an API key. Keys are left as is.
+func interpolateNode(node *yaml.Node) ([]string, error) {
+	var secrets []string
+	var errs []error
+
+	var walk func(node *yaml.Node, isKey bool, secret bool)
+	walk = func(node *yaml.Node, isKey bool, secret bool) {
+		if node.Kind == yaml.ScalarNode {
+			if !isKey && strings.Contains(node.Value, "${") {
+				replaced := interpolation.ReplaceAllStringFunc(node.Value, func(match string) string {
+					if match == "$${" {
+						return "${"
+					}
+					reference := match[2 : len(match)-1]
+					value, err := resolveReference(reference)
+					if err != nil {
+						errs = append(errs, fmt.Errorf("line %d: %w", node.Line, err))
+						return match
+					}
+					if secret || strings.HasPrefix(reference, "file:") {
+						secrets = append(secrets, value)
+					}
+					return value
+				})
+				if replaced != node.Value {
+					node.Value = replaced
+					// let the value be resolved again, e.g. to an int
+					if node.Style == 0 {
+						node.Tag = ""
+					}
+				}
+			}
+			return
+		}
+		for i, child := range node.Content {
+			switch {
+			case node.Kind != yaml.MappingNode:
+				walk(child, false, secret)
+			case i%2 == 0:
+				walk(child, true, false)
+			default:
+				key := node.Content[i-1].Value
+				walk(child, false, secret || secretKeys[key] != nil || key == "headers" || key == "apiKeys")
+			}
+		}
+	}
+	walk(node, false, false)
+
+	return secrets, errors.Join(errs...)
+}
+
+func resolveReference(reference string) (string, error) {
+	if path, ok := strings.CutPrefix(reference, "file:"); ok {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("${%s}: %w", reference, err)
+		}
+		// secret files usually end with a newline
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	if !envName.MatchString(reference) {
+		return "", fmt.Errorf("${%s}: invalid environment variable name", reference)
+	}
+	value, ok := os.LookupEnv(reference)
+	if !ok {
+		return "", fmt.Errorf("${%s}: environment variable is not set", reference)
+	}
+	return value, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInterpolateNode(t *testing.T) {
+	t.Setenv("CERTALERT_TEST_VALUE", "hello")
+	t.Setenv("CERTALERT_TEST_NUMBER", "8")
+	t.Setenv("CERTALERT_TEST_TOKEN", "s3cr3t-token")
+
+	secretFile := filepath.Join(t.TempDir(), "secret")
+	if err := os.WriteFile(secretFile, []byte("s3cr3t-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]any
+		secrets []string
+		err     string
+	}{
+		{
+			name:  "environment variable",
+			input: "a: x-${CERTALERT_TEST_VALUE}-y",
+			want:  map[string]any{"a": "x-hello-y"},
+		},
+		{
+			name:  "escape",
+			input: "a: $${CERTALERT_TEST_VALUE} ${CERTALERT_TEST_VALUE}",
+			want:  map[string]any{"a": "${CERTALERT_TEST_VALUE} hello"},
+		},
+		{
+			name:    "file without trailing newline",
+			input:   "a: ${file:" + secretFile + "}",
+			want:    map[string]any{"a": "s3cr3t-file"},
+			secrets: []string{"s3cr3t-file"},
+		},
+		{
+			name:  "plain value is retagged",
+			input: "workers: ${CERTALERT_TEST_NUMBER}",
+			want:  map[string]any{"workers": 8},
+		},
+		{
+			name:  "quoted value stays a string",
+			input: `workers: "${CERTALERT_TEST_NUMBER}"`,
+			want:  map[string]any{"workers": "8"},
+		},
+		{
+			name:  "keys are left as is",
+			input: "${CERTALERT_TEST_VALUE}: a",
+			want:  map[string]any{"${CERTALERT_TEST_VALUE}": "a"},
+		},
+		{
+			name:    "secret options",
+			input:   "shoutrrrURL: discord://${CERTALERT_TEST_TOKEN}@id\nheaders:\n  Authorization: Bearer ${CERTALERT_TEST_TOKEN}\napiKeys: [\"${CERTALERT_TEST_VALUE}\"]",
+			want:    map[string]any{"shoutrrrURL": "discord://s3cr3t-token@id", "headers": map[string]any{"Authorization": "Bearer s3cr3t-token"}, "apiKeys": []any{"hello"}},
+			secrets: []string{"s3cr3t-token", "s3cr3t-token", "hello"},
+		},
+		{
+			name:  "unset variable",
+			input: "a: b\nc: ${CERTALERT_TEST_UNSET}",
+			err:   "line 2: ${CERTALERT_TEST_UNSET}: environment variable is not set",
+		},
+		{
+			name:  "invalid name",
+			input: "a: ${1X}",
+			err:   "line 1: ${1X}: invalid environment variable name",
+		},
+		{
+			name:  "missing file",
+			input: "a: ${file:/nonexistent/secret}",
+			err:   "line 1: ${file:/nonexistent/secret}: open /nonexistent/secret",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var document yaml.Node
+			if err := yaml.Unmarshal([]byte(test.input), &document); err != nil {
+				t.Fatal(err)
+			}
+			secrets, err := interpolateNode(&document)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("got error %v, want %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got map[string]any
+			if err := document.Decode(&got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+			if !reflect.DeepEqual(secrets, test.secrets) {
+				t.Errorf("got secrets %q, want %q", secrets, test.secrets)
+			}
+		})
+	}
+}
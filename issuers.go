@@ -0,0 +1,171 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+// knownCAAIdentifiers maps the issuer domain names CAs use in CAA records
+// to the organization names found in the issuer DN of their certificates.
+// The names are compared after normalizeOrganization.
+var knownCAAIdentifiers = map[string][]string{
+	"letsencrypt.org":   {"Let's Encrypt", "Internet Security Research Group"},
+	"pki.goog":          {"Google Trust Services LLC", "Google Trust Services"},
+	"digicert.com":      {"DigiCert Inc"},
+	"geotrust.com":      {"DigiCert Inc", "GeoTrust Inc"},
+	"rapidssl.com":      {"DigiCert Inc", "GeoTrust Inc"},
+	"thawte.com":        {"DigiCert Inc", "thawte Inc"},
+	"symantec.com":      {"DigiCert Inc", "Symantec Corporation"},
+	"sectigo.com":       {"Sectigo Limited", "COMODO CA Limited", "The USERTRUST Network"},
+	"comodoca.com":      {"Sectigo Limited", "COMODO CA Limited", "The USERTRUST Network"},
+	"comodo.com":        {"Sectigo Limited", "COMODO CA Limited", "The USERTRUST Network"},
+	"usertrust.com":     {"Sectigo Limited", "The USERTRUST Network"},
+	"zerossl.com":       {"ZeroSSL"},
+	"globalsign.com":    {"GlobalSign nv-sa", "GlobalSign"},
+	"amazon.com":        {"Amazon"},
+	"amazontrust.com":   {"Amazon"},
+	"awstrust.com":      {"Amazon"},
+	"amazonaws.com":     {"Amazon"},
+	"ssl.com":           {"SSL Corporation", "SSL Corp"},
+	"entrust.net":       {"Entrust Inc", "Entrust Limited"},
+	"godaddy.com":       {"GoDaddy.com Inc", "Starfield Technologies Inc"},
+	"starfieldtech.com": {"Starfield Technologies Inc", "GoDaddy.com Inc"},
+	"buypass.com":       {"Buypass AS-983163327"},
+	"buypass.no":        {"Buypass AS-983163327"},
+	"identrust.com":     {"IdenTrust"},
+	"microsoft.com":     {"Microsoft Corporation"},
+	"certum.pl":         {"Asseco Data Systems S.A.", "Unizeto Technologies S.A."},
+	"certum.eu":         {"Asseco Data Systems S.A.", "Unizeto Technologies S.A."},
+	"harica.gr":         {"Hellenic Academic and Research Institutions CA", "Hellenic Academic and Research Institutions Cert. Authority"},
+	"actalis.it":        {"Actalis S.p.A.", "Actalis S.p.A./03358520967"},
+}
+
+// normalizeOrganization lowercases the name and drops commas, periods and
+// repeated spaces, so that e.g. "DigiCert, Inc." equals "DigiCert Inc".
+func normalizeOrganization(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r == ',' || r == '.' {
+			return ' '
+		}
+		return r
+	}, strings.ToLower(name))
+	return strings.Join(strings.Fields(name), " ")
+}
+
+// caaIdentifierMatchesIssuer reports whether the certificate could have been
+// issued by the CA behind the CAA identifier, judging by its issuer DN. The
+// organization must equal one of the CA's, but any CA can put any name into
+// a DN, so only the issuer's public key proves who issued the certificate.
+func caaIdentifierMatchesIssuer(identifier string, cert *x509.Certificate) bool {
+	organizations, ok := knownCAAIdentifiers[strings.ToLower(identifier)]
+	if !ok {
+		return false
+	}
+	for _, issuerOrganization := range cert.Issuer.Organization {
+		issuerOrganization = normalizeOrganization(issuerOrganization)
+		for _, organization := range organizations {
+			if issuerOrganization == normalizeOrganization(organization) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// spkiHash returns the base64 encoded SHA-256 hash of the certificate's
+// SubjectPublicKeyInfo, the same format as HPKP pins.
+func spkiHash(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// spkiFingerprint is spkiHash hex encoded, like the certificate fingerprint,
+// for webhooks and the match history.
+func spkiFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// AllowedIssuersConfig turns a watcher into an unauthorized CA detector: the
+// watcher only matches certificates whose issuer is not covered by any entry.
+// Names and CAA identifiers are checked against the issuer DN, which a CA
+// can fill in freely; only SPKI hashes identify the issuing key itself.
+type AllowedIssuersConfig struct {
+	Names          []string `yaml:"names"`          // wildcards against the issuer CN and O
+	SPKIHashes     []string `yaml:"spkiHashes"`     // base64 SHA-256 of the issuing CA's public key
+	CAAIdentifiers []string `yaml:"caaIdentifiers"` // e.g. letsencrypt.org
+
+	names []glob.Glob `yaml:"-"`
+}
+
+func (a *AllowedIssuersConfig) compile(path string) error {
+	if len(a.Names) == 0 && len(a.SPKIHashes) == 0 && len(a.CAAIdentifiers) == 0 {
+		return fmt.Errorf("%s: at least one of names, spkiHashes or caaIdentifiers is required", path)
+	}
+
+	names, err := compileGlobs(path+".names", a.Names)
+	if err != nil {
+		return err
+	}
+	a.names = names
+
+	for i, hash := range a.SPKIHashes {
+		hash = strings.TrimPrefix(strings.TrimSpace(hash), "sha256/")
+		decoded, err := base64.StdEncoding.DecodeString(hash)
+		if err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("%s.spkiHashes[%d]: expected a base64 encoded sha256 hash, got %q", path, i, a.SPKIHashes[i])
+		}
+		a.SPKIHashes[i] = hash
+	}
+
+	for i, identifier := range a.CAAIdentifiers {
+		if _, ok := knownCAAIdentifiers[strings.ToLower(identifier)]; !ok {
+			known := make([]string, 0, len(knownCAAIdentifiers))
+			for k := range knownCAAIdentifiers {
+				known = append(known, k)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("%s.caaIdentifiers[%d]: unknown identifier %q, use names instead or one of: %s", path, i, identifier, strings.Join(known, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Allows reports whether the certificate was issued by an allowed CA.
+func (a *AllowedIssuersConfig) Allows(entry *CertificateEntry) bool {
+	cert := entry.Certificate
+
+	issuerNames := append([]string{cert.Issuer.CommonName}, cert.Issuer.Organization...)
+	if matchAnyGlob(a.names, issuerNames) {
+		return true
+	}
+
+	for _, identifier := range a.CAAIdentifiers {
+		if caaIdentifierMatchesIssuer(identifier, cert) {
+			return true
+		}
+	}
+
+	if len(a.SPKIHashes) > 0 {
+		issuer := entry.IssuerCertificate()
+		if issuer == nil {
+			return false
+		}
+		hash := spkiHash(issuer)
+		for _, allowed := range a.SPKIHashes {
+			if allowed == hash {
+				return true
+			}
+		}
+	}
+
+	return false
+}
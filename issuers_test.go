@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	ctgo "github.com/google/certificate-transparency-go"
+)
+
+// issuedEntry returns an entry for a leaf certificate issued by a CA with the
+// given issuer DN, and the CA's certificate, which is submitted as chain.
+func issuedEntry(t *testing.T, issuer pkix.Name) (*CertificateEntry, *x509.Certificate) {
+	t.Helper()
+	return issueCertificate(t, issuer, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "www.example.com"},
+		DNSNames:     []string{"www.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	})
+}
+
+// issueCertificate is issuedEntry for a leaf certificate from the template.
+func issueCertificate(t *testing.T, issuer pkix.Name, leafTemplate *x509.Certificate) (*CertificateEntry, *x509.Certificate) {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               issuer,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caRaw, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leafRaw, err := x509.CreateCertificate(rand.Reader, leafTemplate, ca, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(leafRaw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &CertificateEntry{Certificate: leaf, Chain: []ctgo.ASN1Cert{{Data: caRaw}}}, ca
+}
+
+func TestAllowedIssuers(t *testing.T) {
+	letsEncrypt := pkix.Name{CommonName: "R11", Organization: []string{"Let's Encrypt"}}
+	otherCA, _ := issuedEntry(t, letsEncrypt)
+	otherHash := spkiHash(otherCA.IssuerCertificate())
+
+	tests := []struct {
+		name    string
+		allowed AllowedIssuersConfig
+		issuer  pkix.Name
+		allows  bool
+		ownHash bool // allow the hash of the issuing CA's key instead
+	}{
+		{
+			name:    "caa identifier",
+			allowed: AllowedIssuersConfig{CAAIdentifiers: []string{"letsencrypt.org"}},
+			issuer:  letsEncrypt,
+			allows:  true,
+		},
+		{
+			name:    "caa identifier with different punctuation and case",
+			allowed: AllowedIssuersConfig{CAAIdentifiers: []string{"DigiCert.com"}},
+			issuer:  pkix.Name{CommonName: "DigiCert Global G2 TLS RSA SHA256 2020 CA1", Organization: []string{"DigiCert, Inc."}},
+			allows:  true,
+		},
+		{
+			name:    "caa identifier of another CA",
+			allowed: AllowedIssuersConfig{CAAIdentifiers: []string{"letsencrypt.org"}},
+			issuer:  pkix.Name{CommonName: "WR1", Organization: []string{"Google Trust Services"}},
+		},
+		{
+			name:    "organization containing the name of an allowed CA",
+			allowed: AllowedIssuersConfig{CAAIdentifiers: []string{"digicert.com", "sectigo.com"}},
+			issuer:  pkix.Name{CommonName: "Reseller CA", Organization: []string{"Not DigiCert Inc Reseller", "Sectigo Limited Partner"}},
+		},
+		{
+			name:    "name wildcard",
+			allowed: AllowedIssuersConfig{Names: []string{"R1?"}},
+			issuer:  letsEncrypt,
+			allows:  true,
+		},
+		{
+			name:    "name without wildcard is exact",
+			allowed: AllowedIssuersConfig{Names: []string{"let's encrypt"}},
+			issuer:  pkix.Name{CommonName: "Copy CA", Organization: []string{"Let's Encrypt Copy"}},
+		},
+		{
+			name:    "spki hash of the issuing CA",
+			allowed: AllowedIssuersConfig{SPKIHashes: []string{otherHash}},
+			issuer:  letsEncrypt,
+			allows:  true,
+			ownHash: true,
+		},
+		{
+			name:    "spki hash of another CA with the same name",
+			allowed: AllowedIssuersConfig{SPKIHashes: []string{otherHash}},
+			issuer:  letsEncrypt,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entry, ca := issuedEntry(t, test.issuer)
+			allowed := test.allowed
+			if test.ownHash {
+				allowed.SPKIHashes = []string{spkiHash(ca)}
+			}
+			if err := allowed.compile("allowedIssuers"); err != nil {
+				t.Fatal(err)
+			}
+			if got := allowed.Allows(entry); got != test.allows {
+				t.Errorf("Allows() = %t, want %t", got, test.allows)
+			}
+		})
+	}
+}
+
+func TestNormalizeOrganization(t *testing.T) {
+	tests := map[string]string{
+		"DigiCert, Inc.":               "digicert inc",
+		"  Sectigo   Limited ":         "sectigo limited",
+		"Actalis S.p.A./03358520967":   "actalis s p a /03358520967",
+		"Let's Encrypt":                "let's encrypt",
+		"Starfield Technologies, Inc.": "starfield technologies inc",
+	}
+	for input, want := range tests {
+		if got := normalizeOrganization(input); got != want {
+			t.Errorf("normalizeOrganization(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
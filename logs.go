@@ -104,3 +104,21 @@ func getGoogleCTLogs(url string, lastModified string) (CtLogUpdate, error) {
 	return update, nil
 
 }
+
+// effectiveLogs returns the logs that are watched: the usable logs of the
+// log list followed by the custom logs of the config.
+func effectiveLogs(logUpdate CtLogUpdate, config *Config) []CtLogUpdateLog {
+	logs := []CtLogUpdateLog{}
+	if config.LogCollection.GoogleLogListURL != "" {
+		logs = append(logs, logUpdate.Logs...)
+	}
+	for _, customLogUrl := range config.LogCollection.LogsURLs {
+		logs = append(logs, CtLogUpdateLog{
+			OperatorName: "unknown",
+			Description:  customLogUrl,
+			Url:          customLogUrl,
+			LogID:        customLogUrl,
+		})
+	}
+	return logs
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,7 +11,6 @@ import (
 	"os"
 	"os/signal"
 	"strconv"
-	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -38,16 +38,62 @@ type ConfigFormat struct {
 	Queries []string
 }
 
+// CertificateEntry is a certificate together with where it was observed.
+type CertificateEntry struct {
+	Certificate *x509.Certificate
+	Chain       []ctgo.ASN1Cert
+	Precert     bool
+	Log         CtLogUpdateLog
+	Index       int64
+
+	issuer       *x509.Certificate
+	issuerParsed bool
+}
+
+// precertSigningEKU marks a Precertificate Signing Certificate (RFC 6962
+// section 3.1), which signs precertificates on behalf of the actual issuer.
+var precertSigningEKU = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 4}
+
+// IssuerCertificate parses the issuer from the submitted chain, or returns
+// nil if the chain is empty or can't be parsed. The chain of a precertificate
+// may start with a precert signing certificate, in which case the issuer is
+// the next one.
+func (e *CertificateEntry) IssuerCertificate() *x509.Certificate {
+	if !e.issuerParsed {
+		e.issuerParsed = true
+		if len(e.Chain) > 0 {
+			e.issuer, _ = x509.ParseCertificate(e.Chain[0].Data)
+		}
+		if e.issuer != nil && isPrecertSigningCertificate(e.issuer) {
+			e.issuer = nil
+			if len(e.Chain) > 1 {
+				e.issuer, _ = x509.ParseCertificate(e.Chain[1].Data)
+			}
+		}
+	}
+	return e.issuer
+}
+
+func isPrecertSigningCertificate(cert *x509.Certificate) bool {
+	for _, usage := range cert.UnknownExtKeyUsage {
+		if usage.Equal(precertSigningEKU) {
+			return true
+		}
+	}
+	return false
+}
+
 type NotifyInstruction struct {
-	Certificate    *x509.Certificate
-	Watchers       []WatcherConfig
-	LogDescription string
+	Certificate *x509.Certificate
+	Entry       *CertificateEntry
+	Watchers    []WatcherConfig
+	Config      *Config // the configuration the certificate was matched with
 }
 
 var MessageQueue = make(chan Message)
 
-func getSTH(root string) (int64, error) {
-	req, err := http.NewRequest("GET", root+"ct/v1/get-sth", nil)
+func getSTH(ctx context.Context, root string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", root+"ct/v1/get-sth", nil)
 	req.Header.Add("user-agent", "github.com/janic0/certalert")
 
 	if err != nil {
@@ -72,14 +118,14 @@ func getSTH(root string) (int64, error) {
 	}{}
 	err = json.Unmarshal(responseText, &decodedResponse)
 	if err != nil {
-		fmt.Println("log sth failed with status", rq.Status)
+		logln("log sth failed with status", rq.Status)
 		return 0, err
 	}
 	return decodedResponse.TreeSize, nil
 }
 
-func getEntries(root string, start int64, end int64) ([]ctgo.LeafEntry, error) {
-	req, err := http.NewRequest("GET", root+"ct/v1/get-entries", nil)
+func getEntries(ctx context.Context, root string, start int64, end int64) ([]ctgo.LeafEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", root+"ct/v1/get-entries", nil)
 	req.Header.Add("user-agent", "github.com/janic0/certalert")
 	if err != nil {
 		return make([]ctgo.LeafEntry, 0), err
@@ -102,28 +148,34 @@ func getEntries(root string, start int64, end int64) ([]ctgo.LeafEntry, error) {
 	}{}
 	err = json.Unmarshal(responseText, &decodedResponse)
 	if err != nil {
-		fmt.Println("log entry failed with status", rq.Status)
+		logln("log entry failed with status", rq.Status)
 		return decodedResponse.Entries, err
 	}
 	return decodedResponse.Entries, nil
 }
 
-func updateLog(ctx context.Context, log CtLogUpdateLog, lastTreeSizes *sync.Map, prometheusLabels prometheus.Labels, config Config, notifyInstructionChannel chan NotifyInstruction) bool {
+func updateLog(ctx context.Context, log CtLogUpdateLog, lastTreeSizes *sync.Map, prometheusLabels prometheus.Labels, config Config, dispatcher *Dispatcher, status *Status) bool {
 
 	timeStart := time.Now()
 
-	treeSize, err := getSTH(log.Url)
+	treeSize, err := getSTH(ctx, log.Url)
 	if err != nil {
-		fmt.Printf("Failed to get STH @ %s: %s\n", log, err.Error())
+		logf("Failed to get STH @ %s: %s\n", log, err.Error())
+		status.LogFailed(log, err)
 		return false
 	}
+	status.LogObserved(log, treeSize)
 
-	lastTreeSizeValue, hasTreeSize := lastTreeSizes.Swap(log.LogID, treeSize)
+	// the position only moves once the entries were handled, so that the
+	// checkpoint of a shutdown that times out doesn't skip the entries
+	lastTreeSizeValue, hasTreeSize := lastTreeSizes.Load(log.LogID)
 
 	prometheusLogTreeSize.With(prometheusLabels).Set(float64(treeSize))
 
 	if !hasTreeSize || lastTreeSizeValue == nil || lastTreeSizeValue.(int64) == int64(0) {
-		fmt.Println("skipping", log.Description, "due to low previous tree size (", lastTreeSizeValue, ")")
+		logln("skipping", log.Description, "due to low previous tree size (", lastTreeSizeValue, ")")
+		lastTreeSizes.Store(log.LogID, treeSize)
+		status.LogProcessed(log, treeSize)
 
 		return true
 	}
@@ -135,23 +187,27 @@ func updateLog(ctx context.Context, log CtLogUpdateLog, lastTreeSizes *sync.Map,
 	// gap of 10m is too big, so we bail
 	if gap > config.LogCollection.MaxHandleableLogGap {
 		prometheusLogIterationsSkipped.With(prometheusLabels).Inc()
-		fmt.Println("skipping", log.Description, "due to low excessive gap (", gap, ")")
+		logln("skipping", log.Description, "due to low excessive gap (", gap, ")")
+		lastTreeSizes.Store(log.LogID, treeSize)
+		status.LogProcessed(log, treeSize)
 		return true
 	}
 
 	if gap == 0 {
+		status.LogProcessed(log, treeSize)
 		return true
 	}
 
 	entriesHandled := int64(0)
 
-	for entriesHandled < gap {
+	for entriesHandled < gap && ctx.Err() == nil {
 
 		prometheusLogEntryRequest.With(prometheusLabels).Inc()
-		currentEntries, err := getEntries(log.Url, lastTreeSize+entriesHandled, treeSize-1)
+		currentEntries, err := getEntries(ctx, log.Url, lastTreeSize+entriesHandled, treeSize-1)
 
 		if err != nil {
-			fmt.Printf("Failed to get entries @ %s: %s\n", log, err.Error())
+			logf("Failed to get entries @ %s: %s\n", log, err.Error())
+			status.LogFailed(log, err)
 			break
 		} else {
 			// batchSize += int64(len(currentEntries))
@@ -159,42 +215,40 @@ func updateLog(ctx context.Context, log CtLogUpdateLog, lastTreeSizes *sync.Map,
 
 				prometheusLogCertsScanned.With(prometheusLabels).Inc()
 
-				rle, err := ctgo.RawLogEntryFromLeaf(lastTreeSize+entriesHandled, &entry)
+				index := lastTreeSize + entriesHandled
+				rle, err := ctgo.RawLogEntryFromLeaf(index, &entry)
 				entriesHandled++
 
 				if err != nil {
-					fmt.Println("Failed to decode entry", err.Error())
+					logln("Failed to decode entry", err.Error())
 					continue
 				}
 
 				cert, err := x509.ParseCertificate(rle.Cert.Data)
 
 				if err != nil {
-					fmt.Println("Failed to parse certificate", err.Error())
+					logln("Failed to parse certificate", err.Error())
 					continue
 				}
 
-				watchers, _ := config.WatchersFor(cert.Subject.CommonName)
-
-				for _, dnsName := range cert.DNSNames {
-
-					prometheusLogDomainsScanned.With(prometheusLabels).Inc()
-
-					dnsNameWatchers, matchedAny := config.WatchersFor(dnsName)
-					if !matchedAny {
-						continue
-					}
-
-					watchers = append(watchers, dnsNameWatchers...)
+				prometheusLogDomainsScanned.With(prometheusLabels).Add(float64(len(cert.DNSNames)))
 
+				certificateEntry := &CertificateEntry{
+					Certificate: cert,
+					Chain:       rle.Chain,
+					Precert:     rle.Leaf.TimestampedEntry.EntryType == ctgo.PrecertLogEntryType,
+					Log:         log,
+					Index:       index,
 				}
+				watchers := config.WatchersForCertificate(certificateEntry)
 
 				if len(watchers) > 0 {
-					notifyInstructionChannel <- NotifyInstruction{
-						Certificate:    cert,
-						Watchers:       watchers,
-						LogDescription: log.Description,
-					}
+					dispatcher.Submit(NotifyInstruction{
+						Certificate: cert,
+						Entry:       certificateEntry,
+						Watchers:    watchers,
+						Config:      &config,
+					})
 				}
 
 			}
@@ -202,34 +256,75 @@ func updateLog(ctx context.Context, log CtLogUpdateLog, lastTreeSizes *sync.Map,
 		}
 	}
 
+	// shutting down, continue where we stopped on the next start
+	if ctx.Err() != nil {
+		lastTreeSizes.Store(log.LogID, lastTreeSize+entriesHandled)
+		return true
+	}
+	lastTreeSizes.Store(log.LogID, treeSize)
+	status.LogProcessed(log, lastTreeSize+entriesHandled)
+
 	prometheusLogIngestDuration.With(prometheusLabels).Observe(time.Since(timeStart).Seconds())
 
 	return true
 
 }
 
-func main() {
+// run watches the logs until it receives a shutdown signal and returns the
+// exit status of the shutdown. The configuration at configPath is reloaded
+// when it changes.
+func run(configPath string) int {
 
-	config, err := LoadConfigFile("config/config.yml")
+	config, err := LoadConfigFile(configPath)
 	if err != nil {
-		panic(fmt.Errorf("failed to read config/config.yml: %s", err.Error()))
+		panic(fmt.Errorf("failed to read %s: %s", configPath, err.Error()))
+	}
+	// fail at startup rather than with the first match
+	PublicSuffixList()
+
+	// changes to the storage configuration are applied after a restart
+	store, err := NewStore(config.Storage)
+	if err != nil {
+		panic("failed to open storage: " + redactSecrets(err.Error()))
+	}
+	// add the watchers managed through the API
+	config, err = LoadConfigWithStore(configPath, store, nil)
+	if err != nil {
+		panic(fmt.Errorf("failed to load watchers: %s", err.Error()))
+	}
+	// changes to the API configuration are applied after a restart
+	stream := NewStream(config.API.StreamBuffer)
+	status := NewStatus()
+	reloader := NewReloader(configPath, store, status, config)
+	apiServer := http.Server{Addr: config.API.Listen, Handler: NewAPI(store, stream, status, reloader)}
+	apiServer.RegisterOnShutdown(stream.Close)
+	if config.API.Listen != "" {
+		go func() {
+			err := apiServer.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
+				logln("failed to start api server: ", err.Error())
+			}
+		}()
 	}
 
 	lastTreeSizes := sync.Map{}
+	if err := loadCheckpoint(config.LogCollection.CheckpointFile, store, &lastTreeSizes); err != nil {
+		logln("failed to load checkpoint:", err.Error())
+	}
 	ctx, cancel := context.WithCancel(context.TODO())
+	go reloader.Run(ctx)
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		select {
-		case <-sigChan:
-			{
-				cancel()
-				return
-			}
+		<-sigChan
+		cancel()
 
-		}
+		// a second signal skips the graceful shutdown
+		<-sigChan
+		logln("received second signal, exiting immediately")
+		os.Exit(1)
 	}()
 
 	logUpdate := CtLogUpdate{}
@@ -241,6 +336,7 @@ func main() {
 	}
 
 	lastLogUpdate := time.Now()
+	logListURL := config.LogCollection.GoogleLogListURL // the list logUpdate is from
 	metricsServer := http.Server{
 		Addr: "0.0.0.0:2112",
 	}
@@ -252,132 +348,161 @@ func main() {
 		go func() {
 			err := metricsServer.ListenAndServe()
 			if err != nil {
-				fmt.Println("failed to start http server: ", err.Error())
+				logln("failed to start http server: ", err.Error())
 			}
 		}()
 	}
 
 	// collect notifications centrally for deduplication
-	notifyInstructionChannel := make(chan NotifyInstruction)
-	defangReplacer := strings.NewReplacer(".", "[.]")
-	go func() {
-
-		coveredCertificateSerials := sync.Map{}
+	outbox, err := NewOutbox(config.Outbox)
+	if err != nil {
+		panic("failed to open outbox: " + err.Error())
+	}
+	if store != nil {
+		outbox.OnDelivered(func(d *Delivery) {
+			if len(d.MatchIDs) == 0 {
+				return
+			}
+			if err := store.MarkNotified(context.Background(), d.MatchIDs, time.Now()); err != nil {
+				logln("failed to mark matches as notified:", err.Error())
+			}
+		})
+	}
 
-		for {
-			select {
+	// the outbox keeps running while the other components shut down
+	outboxCtx, stopOutbox := context.WithCancel(context.Background())
+	go outbox.Run(outboxCtx)
 
-			case entry := <-notifyInstructionChannel:
-				{
+	throttle := NewThrottle(outbox)
+	digester := NewDigester(throttle)
+	coveredCertificateSerials := sync.Map{}
 
-					certSerial := entry.Certificate.SerialNumber.String()
-					_, isDuplicate := coveredCertificateSerials.LoadOrStore(certSerial, true)
-					if isDuplicate {
-						// skip
-						continue
-					}
+	// changes to the dispatch configuration are applied after a restart
+	dispatcher := NewDispatcher(config.Dispatch, func(entry NotifyInstruction) {
 
-					title := "Certalert: Found matching certificate"
-					message := fmt.Sprintf(
-						"Issuer: %s\nSubject: %s\nDNS Names: %s\nLog: %s\nValid after: %s\nValid until: %s\nSerial: %X",
-						entry.Certificate.Issuer.String(),
-						defangReplacer.Replace(entry.Certificate.Subject.String()),
-						defangReplacer.Replace(strings.Join(entry.Certificate.DNSNames, ", ")),
-						entry.LogDescription,
-						entry.Certificate.NotBefore.String(),
-						entry.Certificate.NotAfter.String(),
-						entry.Certificate.SerialNumber,
-					)
-
-					for _, watcher := range entry.Watchers {
-						errors := watcher.Notify(title, message)
-						for _, err := range errors {
-							if err == nil {
-								continue
-							}
-							fmt.Println("failed to notify watcher", err)
+		watchers := firstSeenWatchers(store, &coveredCertificateSerials, entry.Certificate, entry.Watchers)
+		if len(watchers) == 0 {
+			// skip
+			return
+		}
 
-						}
+		var verdicts []CAAVerdict
+		if entry.Config.caa != nil {
+			verdicts = entry.Config.caa.Check(entry.Certificate)
+		}
 
-					}
+		for _, watcher := range watchers {
+			data := NewTemplateData(entry, &watcher, verdicts)
 
+			if store != nil {
+				record := NewMatchRecord(data)
+				if err := store.RecordMatch(context.Background(), record); err != nil {
+					logln("failed to store match:", err.Error())
 				}
-			case <-ctx.Done():
-				{
+				data.matchID = record.ID
+			}
+			stream.Publish(data)
+			status.WatcherMatched(&watcher)
+			prometheusWatcherMatches.WithLabelValues(watcher.Tenant, watcherLabel(&watcher)).Inc()
+
+			// critical findings are not held back for a digest by default
+			if watcher.Digest != nil && !watcher.Digest.Bypasses(data.Severity) {
+				digester.Add(watcher, data)
+				continue
+			}
 
-					// graceful exit
-					break
+			errors := watcher.Notify(throttle, data)
+			for _, err := range errors {
+				if err == nil {
+					continue
 				}
+				logln("failed to notify watcher", err.Error())
 
 			}
-		}
 
-	}()
+		}
+	})
 
 	// monitor amount of buffered items
 	promauto.NewGaugeFunc(prometheus.GaugeOpts{
 		Name: "certalert_instruction_channel_buffered_items",
 		Help: "The amount of batches currently waiting to be completed",
 	}, func() float64 {
-		return float64(len(notifyInstructionChannel))
+		return float64(dispatcher.Len())
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "certalert_dispatch_buffer_capacity",
+		Help: "The amount of matched certificates the dispatch buffer can hold",
+	}, func() float64 {
+		return float64(dispatcher.Cap())
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "certalert_stream_subscribers",
+		Help: "The amount of clients connected to the match stream",
+	}, func() float64 {
+		return float64(stream.Subscribers())
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "certalert_outbox_pending_deliveries",
+		Help: "The amount of notifications waiting to be delivered",
+	}, func() float64 {
+		return float64(outbox.Depth())
+	})
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "certalert_digest_pending_matches",
+		Help: "The amount of matches waiting to be sent as part of a digest",
+	}, func() float64 {
+		return float64(digester.Pending())
 	})
 
 	lockMap := map[string]*sync.Mutex{}
 	failureStreak := sync.Map{}
+	logRoutines := sync.WaitGroup{}
 
 	for {
 
-		// reload configuration in every iteration
-		config, err := LoadConfigFile("config/config.yml")
-		if err != nil {
-			fmt.Println("failed to load new config:", err.Error())
-			time.Sleep(time.Second)
-			continue
-		}
+		// the reloader swaps the configuration when it changes
+		config = reloader.Config()
 
 		select {
 
 		case <-ctx.Done():
 			{
-				fmt.Println("recevied shutdown signal, shutting down...")
-				metricsServer.Shutdown(context.TODO())
-				return
+				logln("recevied shutdown signal, shutting down...")
+				return shutdown(config.ShutdownTimeout.Duration, []*http.Server{&metricsServer, &apiServer}, &logRoutines, dispatcher, digester, throttle, outbox, stopOutbox, store, func() error {
+					return saveCheckpoint(config.LogCollection.CheckpointFile, store, &lastTreeSizes)
+				})
 			}
 
 		case <-time.After(config.LogCollection.LogRenewalInterval.Duration):
 			{
 
-				logsToUpdate := []CtLogUpdateLog{}
-
-				if config.LogCollection.GoogleLogListURL != "" {
+				// not only on shutdown, so that a crash doesn't lose the
+				// positions since the start
+				if err := saveCheckpoint(config.LogCollection.CheckpointFile, store, &lastTreeSizes); err != nil {
+					logln("failed to save checkpoint:", err.Error())
+				}
 
-					// update if needed?
-					if time.Now().Sub(lastLogUpdate).Minutes() > 5 {
-						newLogUpdate, err := getGoogleCTLogs(config.LogCollection.GoogleLogListURL, logUpdate.LastModified)
-						lastLogUpdate = time.Now()
-						if err != nil {
-							fmt.Println("failed to update ct logs. retrying at next iteration: ", err.Error())
-						} else {
-							logUpdate = newLogUpdate
-						}
+				// update if needed?
+				if url := config.LogCollection.GoogleLogListURL; url != "" && (url != logListURL || time.Now().Sub(lastLogUpdate).Minutes() > 5) {
+					lastModified := logUpdate.LastModified
+					if url != logListURL {
+						lastModified = ""
 					}
-
-					for _, log := range logUpdate.Logs {
-						logsToUpdate = append(logsToUpdate, log)
+					newLogUpdate, err := getGoogleCTLogs(url, lastModified)
+					lastLogUpdate = time.Now()
+					if err != nil {
+						logln("failed to update ct logs. retrying at next iteration: ", err.Error())
+					} else {
+						logUpdate, logListURL = newLogUpdate, url
 					}
-
 				}
 
-				for _, customLogUrl := range config.LogCollection.LogsURLs {
-					logsToUpdate = append(logsToUpdate, CtLogUpdateLog{
-						OperatorName: "unknown",
-						Description:  customLogUrl,
-						Url:          customLogUrl,
-						LogID:        customLogUrl,
-					})
-				}
-
-				for _, log := range logUpdate.Logs {
+				for _, log := range effectiveLogs(logUpdate, config) {
 
 					mutex, hasMutex := lockMap[log.LogID]
 					if !hasMutex {
@@ -394,8 +519,10 @@ func main() {
 						continue
 					}
 
+					logRoutines.Add(1)
 					go func() {
-						wasOk := updateLog(ctx, log, &lastTreeSizes, prometheusLabels, *config, notifyInstructionChannel)
+						defer logRoutines.Done()
+						wasOk := updateLog(ctx, log, &lastTreeSizes, prometheusLabels, *config, dispatcher, status)
 						if !wasOk {
 
 							value, loaded := failureStreak.LoadOrStore(log.LogID, int64(0))
@@ -404,9 +531,14 @@ func main() {
 							}
 
 							waitingCount := (value.(int64) * 5) + 1
+							backoff := time.Duration(waitingCount) * time.Minute
+							status.LogBackoff(log, value.(int64)+1, time.Now().Add(backoff))
 
 							// fmt.Println(log.Description, "failed, waiting ", waitingCount*60, "s")
-							time.Sleep(time.Duration(waitingCount) * time.Minute)
+							select {
+							case <-ctx.Done():
+							case <-time.After(backoff):
+							}
 						} else {
 							failureStreak.Delete(log.LogID)
 						}
@@ -424,3 +556,77 @@ func main() {
 	}
 
 }
+
+// shutdown waits for the log goroutines to stop, hands everything still in
+// flight to the outbox and gives the outbox a last chance to deliver, all
+// within the timeout. It returns the exit status: 0 if nothing was lost.
+func shutdown(timeout time.Duration, servers []*http.Server, logRoutines *sync.WaitGroup, dispatcher *Dispatcher, digester *Digester, throttle *Throttle, outbox *Outbox, stopOutbox context.CancelFunc, store Store, checkpoint func() error) int {
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	status := 0
+	for _, server := range servers {
+		server.Shutdown(ctx)
+	}
+
+	logsDone := make(chan struct{})
+	go func() {
+		logRoutines.Wait()
+		close(logsDone)
+	}()
+	// the positions of the logs move once matches are in the dispatch
+	// buffer, so they are only saved if every match was dispatched
+	drained := false
+	select {
+	case <-logsDone:
+		if err := dispatcher.Drain(ctx); err != nil {
+			logln("failed to dispatch pending matches:", err.Error())
+			status = 1
+		} else {
+			drained = true
+		}
+	case <-ctx.Done():
+		logln("timed out waiting for logs to stop")
+		status = 1
+	}
+
+	digester.FlushAll()
+	throttle.FlushFolded()
+
+	stopOutbox()
+	if err := outbox.Drain(ctx); err != nil {
+		logln("failed to deliver pending notifications:", err.Error())
+		status = 1
+	}
+
+	if !drained {
+		logln("not saving the checkpoint, the logs are read again from the previous one on the next start")
+	} else if err := checkpoint(); err != nil {
+		logln("failed to save checkpoint:", err.Error())
+		status = 1
+	}
+
+	if depth := outbox.Depth(); depth > 0 {
+		if outbox.Persistent() {
+			logln(depth, "notifications are kept in the outbox until the next start")
+		} else {
+			logln(depth, "notifications could not be delivered and are lost")
+			status = 1
+		}
+	}
+	if err := outbox.Close(ctx); err != nil {
+		logln("failed to close outbox:", err.Error())
+		status = 1
+	}
+	if store != nil {
+		if err := store.Close(); err != nil {
+			logln("failed to close storage:", err.Error())
+			status = 1
+		}
+	}
+
+	return status
+}
@@ -0,0 +1,99 @@
+package matcher
+
+// ahoCorasick finds all keywords in a name with a single pass. The automaton
+// is built as a full transition table over the bytes that appear in any
+// keyword; every other byte leads back to the root.
+type ahoCorasick struct {
+	classes  [256]uint16 // 0 is every byte that appears in no keyword
+	nClasses int
+	delta    []int32 // delta[state*nClasses+class] is the next state
+	outputs  [][]keyword
+}
+
+func newAhoCorasick(keywords []keyword) *ahoCorasick {
+	ac := &ahoCorasick{nClasses: 1}
+	for _, kw := range keywords {
+		for i := 0; i < len(kw.text); i++ {
+			if ac.classes[kw.text[i]] == 0 {
+				ac.classes[kw.text[i]] = uint16(ac.nClasses)
+				ac.nClasses++
+			}
+		}
+	}
+
+	// build the trie of keywords, state 0 is the root
+	ac.delta = make([]int32, ac.nClasses)
+	ac.outputs = [][]keyword{nil}
+	for _, kw := range keywords {
+		state := int32(0)
+		for i := 0; i < len(kw.text); i++ {
+			idx := int(state)*ac.nClasses + int(ac.classes[kw.text[i]])
+			if ac.delta[idx] == 0 {
+				ac.delta[idx] = int32(len(ac.outputs))
+				ac.delta = append(ac.delta, make([]int32, ac.nClasses)...)
+				ac.outputs = append(ac.outputs, nil)
+			}
+			state = ac.delta[idx]
+		}
+		ac.outputs[state] = append(ac.outputs[state], kw)
+	}
+
+	// turn the trie into a DFA in breadth first order, so that the failure
+	// state of every node is complete before the node itself is visited
+	fail := make([]int32, len(ac.outputs))
+	queue := []int32{}
+	for c := 1; c < ac.nClasses; c++ {
+		if next := ac.delta[c]; next != 0 {
+			queue = append(queue, next)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		ac.outputs[state] = append(ac.outputs[state], ac.outputs[fail[state]]...)
+		for c := 1; c < ac.nClasses; c++ {
+			idx := int(state)*ac.nClasses + c
+			fallback := ac.delta[int(fail[state])*ac.nClasses+c]
+			if next := ac.delta[idx]; next != 0 {
+				fail[next] = fallback
+				queue = append(queue, next)
+			} else {
+				ac.delta[idx] = fallback
+			}
+		}
+	}
+
+	return ac
+}
+
+func (ac *ahoCorasick) match(name string, fn func(id int)) {
+	if len(ac.outputs) <= 1 {
+		return
+	}
+	state := int32(0)
+	for i := 0; i < len(name); i++ {
+		state = ac.delta[int(state)*ac.nClasses+int(ac.classes[name[i]])]
+		for _, kw := range ac.outputs[state] {
+			end := i + 1
+			switch kw.anchor {
+			case anchorPrefix:
+				if end != len(kw.text) {
+					continue
+				}
+			case anchorSuffix:
+				if end != len(name) {
+					continue
+				}
+			case anchorExact:
+				if end != len(name) || end != len(kw.text) {
+					continue
+				}
+			case anchorVerify:
+				if !kw.verify(name) {
+					continue
+				}
+			}
+			fn(kw.id)
+		}
+	}
+}
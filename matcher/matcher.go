@@ -0,0 +1,314 @@
+// Package matcher matches DNS names against many wildcard and regular
+// expression patterns at once.
+//
+// Patterns are sorted into the cheapest structure that can evaluate them:
+//
+//   - literal names and "*.suffix" globs go into a trie over the reversed
+//     labels of the name,
+//   - "*keyword*", "prefix*", "*suffix" globs and plain literal regular
+//     expressions become keywords of an Aho-Corasick automaton,
+//   - everything else falls back to a compiled glob or regexp. If the
+//     pattern contains a literal that every match has to include, that
+//     literal becomes a keyword and the pattern is only evaluated for names
+//     containing it; otherwise it is checked for every name.
+//
+// Matching semantics are the same as gobwas/glob without separators and
+// unanchored Go regular expressions.
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"strings"
+
+	"github.com/gobwas/glob"
+)
+
+const globSpecialChars = "*?[]{}\\!"
+
+type anchor uint8
+
+const (
+	anchorNone   anchor = iota // keyword may appear anywhere
+	anchorPrefix               // name starts with the keyword
+	anchorSuffix               // name ends with the keyword
+	anchorExact                // name equals the keyword
+	anchorVerify               // name contains the keyword, verify has to match too
+)
+
+// minRequiredLiteral is the shortest literal worth prefiltering a fallback
+// pattern with.
+const minRequiredLiteral = 3
+
+type keyword struct {
+	id     int
+	text   string
+	anchor anchor
+	verify func(string) bool
+}
+
+type fallback struct {
+	id    int
+	match func(string) bool
+}
+
+// Stats describes how the patterns were compiled.
+type Stats struct {
+	Trie     int // literal names and label suffixes
+	Keywords int // Aho-Corasick keywords
+	Always   int // patterns matching every name
+	Filtered int // globs and regexps evaluated when their required literal is found
+	Fallback int // globs and regexps checked one by one
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d trie, %d keyword, %d match-all, %d prefiltered, %d fallback", s.Trie, s.Keywords, s.Always, s.Filtered, s.Fallback)
+}
+
+type Builder struct {
+	trie     *labelNode
+	keywords []keyword
+	always   []int
+	fallback []fallback
+	stats    Stats
+}
+
+func NewBuilder() *Builder {
+	return &Builder{trie: newLabelNode()}
+}
+
+// AddGlob adds a gobwas/glob pattern under the given id.
+func (b *Builder) AddGlob(id int, pattern string) error {
+	inner, leading, trailing := strings.TrimPrefix(pattern, "*"), strings.HasPrefix(pattern, "*"), false
+	if strings.HasSuffix(inner, "*") {
+		inner, trailing = strings.TrimSuffix(inner, "*"), true
+	}
+
+	if !strings.ContainsAny(inner, globSpecialChars) {
+		switch {
+		case !leading && !trailing:
+			b.trie.insert(inner, id, false)
+			b.stats.Trie++
+			return nil
+		case inner == "":
+			b.always = append(b.always, id)
+			b.stats.Always++
+			return nil
+		case leading && !trailing && strings.HasPrefix(inner, "."):
+			b.trie.insert(inner[1:], id, true)
+			b.stats.Trie++
+			return nil
+		case leading && trailing:
+			b.addKeyword(id, inner, anchorNone)
+			return nil
+		case leading:
+			b.addKeyword(id, inner, anchorSuffix)
+			return nil
+		default:
+			b.addKeyword(id, inner, anchorPrefix)
+			return nil
+		}
+	}
+
+	g, err := glob.Compile(pattern)
+	if err != nil {
+		return err
+	}
+	b.addFallback(id, requiredGlobLiteral(pattern), g.Match)
+	return nil
+}
+
+// AddRegexp adds an (unanchored) Go regular expression under the given id.
+func (b *Builder) AddRegexp(id int, pattern string) error {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return err
+	}
+
+	if text, a, ok := literalRegexp(pattern); ok {
+		if text == "" {
+			if a == anchorExact {
+				b.addFallback(id, "", re.MatchString)
+			} else {
+				b.always = append(b.always, id)
+				b.stats.Always++
+			}
+			return nil
+		}
+		if a == anchorExact {
+			b.trie.insert(text, id, false)
+			b.stats.Trie++
+			return nil
+		}
+		b.addKeyword(id, text, a)
+		return nil
+	}
+
+	b.addFallback(id, requiredRegexpLiteral(pattern), re.MatchString)
+	return nil
+}
+
+func (b *Builder) addKeyword(id int, text string, a anchor) {
+	b.keywords = append(b.keywords, keyword{id: id, text: text, anchor: a})
+	b.stats.Keywords++
+}
+
+func (b *Builder) addFallback(id int, required string, match func(string) bool) {
+	if len(required) >= minRequiredLiteral {
+		b.keywords = append(b.keywords, keyword{id: id, text: required, anchor: anchorVerify, verify: match})
+		b.stats.Filtered++
+		return
+	}
+	b.fallback = append(b.fallback, fallback{id: id, match: match})
+	b.stats.Fallback++
+}
+
+// requiredGlobLiteral returns the longest literal outside of any character
+// class or alternative, which every name matching the glob contains.
+func requiredGlobLiteral(pattern string) string {
+	if strings.Contains(pattern, "\\") {
+		return ""
+	}
+
+	longest, current, depth := "", "", 0
+	for _, r := range pattern {
+		switch {
+		case r == '[' || r == '{':
+			depth++
+		case r == ']' || r == '}':
+			depth--
+		case depth == 0 && r != '*' && r != '?':
+			current += string(r)
+			if len(current) > len(longest) {
+				longest = current
+			}
+			continue
+		}
+		current = ""
+	}
+	return longest
+}
+
+// requiredRegexpLiteral returns the longest case sensitive literal of a
+// top-level concatenation, which every match has to contain.
+func requiredRegexpLiteral(pattern string) string {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return ""
+	}
+	re = re.Simplify()
+	if re.Op != syntax.OpConcat {
+		return ""
+	}
+
+	longest := ""
+	for _, sub := range re.Sub {
+		if sub.Op == syntax.OpLiteral && sub.Flags&syntax.FoldCase == 0 && len(string(sub.Rune)) > len(longest) {
+			longest = string(sub.Rune)
+		}
+	}
+	return longest
+}
+
+// literalRegexp recognizes regular expressions that are a literal string,
+// optionally anchored with ^ and $.
+func literalRegexp(pattern string) (string, anchor, bool) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", anchorNone, false
+	}
+	re = re.Simplify()
+
+	parts := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		parts = re.Sub
+	}
+
+	begin, end := false, false
+	if len(parts) > 0 && parts[0].Op == syntax.OpBeginText {
+		begin, parts = true, parts[1:]
+	}
+	if len(parts) > 0 && parts[len(parts)-1].Op == syntax.OpEndText {
+		end, parts = true, parts[:len(parts)-1]
+	}
+
+	text := ""
+	switch {
+	case len(parts) == 0:
+	case len(parts) == 1 && parts[0].Op == syntax.OpLiteral && parts[0].Flags&syntax.FoldCase == 0:
+		text = string(parts[0].Rune)
+	case len(parts) == 1 && parts[0].Op == syntax.OpEmptyMatch:
+	default:
+		return "", anchorNone, false
+	}
+
+	switch {
+	case begin && end:
+		return text, anchorExact, true
+	case begin:
+		return text, anchorPrefix, true
+	case end:
+		return text, anchorSuffix, true
+	default:
+		return text, anchorNone, true
+	}
+}
+
+func (b *Builder) Build() *Matcher {
+	return &Matcher{
+		trie:     b.trie,
+		keywords: newAhoCorasick(b.keywords),
+		always:   append([]int(nil), b.always...),
+		fallback: append([]fallback(nil), b.fallback...),
+		stats:    b.stats,
+	}
+}
+
+type Matcher struct {
+	trie     *labelNode
+	keywords *ahoCorasick
+	always   []int
+	fallback []fallback
+	stats    Stats
+}
+
+func (m *Matcher) Stats() Stats {
+	return m.stats
+}
+
+// Match returns the sorted ids of all patterns matching the name.
+func (m *Matcher) Match(name string) []int {
+	var ids []int
+	m.Each(name, func(id int) {
+		ids = append(ids, id)
+	})
+	if len(ids) < 2 {
+		return ids
+	}
+
+	sort.Ints(ids)
+	out := ids[:1]
+	for _, id := range ids[1:] {
+		if id != out[len(out)-1] {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// Each calls fn for every pattern matching the name. An id can be reported
+// more than once.
+func (m *Matcher) Each(name string, fn func(id int)) {
+	for _, id := range m.always {
+		fn(id)
+	}
+	m.trie.match(name, fn)
+	m.keywords.match(name, fn)
+	for _, f := range m.fallback {
+		if f.match(name) {
+			fn(f.id)
+		}
+	}
+}
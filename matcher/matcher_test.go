@@ -0,0 +1,236 @@
+package matcher
+
+import (
+	"fmt"
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/gobwas/glob"
+)
+
+// patternSet builds n patterns in roughly the mix we see in real configs:
+// mostly domain suffixes, some keywords, exact names and a few regexps.
+func patternSet(n int) (globs []string, regexps []string) {
+	for i := 0; i < n; i++ {
+		switch i % 10 {
+		case 0, 1, 2, 3, 4, 5:
+			globs = append(globs, fmt.Sprintf("*.domain%d.com", i))
+		case 6, 7:
+			globs = append(globs, fmt.Sprintf("*keyword%d*", i))
+		case 8:
+			globs = append(globs, fmt.Sprintf("www.exact%d.org", i))
+		case 9:
+			if i%20 == 9 {
+				regexps = append(regexps, fmt.Sprintf(`\.suffix%d\.net$`, i))
+			} else {
+				regexps = append(regexps, fmt.Sprintf(`^[a-z]+\.regexp%d\.io$`, i))
+			}
+		}
+	}
+	return globs, regexps
+}
+
+// names returns names of which about one in a hundred matches a pattern.
+func names(n int, patterns int) []string {
+	r := rand.New(rand.NewSource(1))
+	out := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		p := r.Intn(patterns)
+		switch r.Intn(500) {
+		case 0:
+			out = append(out, fmt.Sprintf("api.domain%d.com", p))
+		case 1:
+			out = append(out, fmt.Sprintf("login-keyword%d-secure.example", p))
+		case 2:
+			out = append(out, fmt.Sprintf("www.exact%d.org", p))
+		case 3:
+			out = append(out, fmt.Sprintf("abc.regexp%d.io", p))
+		case 4:
+			out = append(out, fmt.Sprintf("x.suffix%d.net", p))
+		default:
+			out = append(out, fmt.Sprintf("host%d.customer%d.cloudprovider.com", r.Intn(1000), r.Intn(100000)))
+		}
+	}
+	return out
+}
+
+type linear struct {
+	globs   []glob.Glob
+	regexps []*regexp.Regexp
+}
+
+// match is the linear scan Config.WatchersFor used before this package.
+func (l *linear) match(name string) []int {
+	var ids []int
+	for i, g := range l.globs {
+		if g.Match(name) {
+			ids = append(ids, i)
+		}
+	}
+	for i, re := range l.regexps {
+		if re.MatchString(name) {
+			ids = append(ids, len(l.globs)+i)
+		}
+	}
+	return ids
+}
+
+func build(b testing.TB, n int) (*linear, *Matcher) {
+	globs, regexps := patternSet(n)
+	l := &linear{}
+	builder := NewBuilder()
+	for i, g := range globs {
+		l.globs = append(l.globs, glob.MustCompile(g))
+		if err := builder.AddGlob(i, g); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for i, r := range regexps {
+		l.regexps = append(l.regexps, regexp.MustCompile(r))
+		if err := builder.AddRegexp(len(globs)+i, r); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return l, builder.Build()
+}
+
+func newMatcher(t *testing.T, globs []string, regexps []string) *Matcher {
+	t.Helper()
+	builder := NewBuilder()
+	for i, g := range globs {
+		if err := builder.AddGlob(i, g); err != nil {
+			t.Fatalf("glob %q: %s", g, err)
+		}
+	}
+	for i, r := range regexps {
+		if err := builder.AddRegexp(len(globs)+i, r); err != nil {
+			t.Fatalf("regexp %q: %s", r, err)
+		}
+	}
+	return builder.Build()
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		globs   []string
+		regexps []string
+		input   string
+		want    []int
+	}{
+		// trie
+		{name: "exact name", globs: []string{"www.example.com"}, input: "www.example.com", want: []int{0}},
+		{name: "exact name, other name", globs: []string{"www.example.com"}, input: "api.example.com"},
+		{name: "exact name, subdomain", globs: []string{"example.com"}, input: "www.example.com"},
+		{name: "suffix", globs: []string{"*.example.com"}, input: "a.b.example.com", want: []int{0}},
+		{name: "suffix, bare domain", globs: []string{"*.example.com"}, input: "example.com"},
+		{name: "suffix, longer label", globs: []string{"*.example.com"}, input: "www.myexample.com"},
+		{name: "anchored literal regexp", regexps: []string{`^www\.example\.com$`}, input: "www.example.com", want: []int{0}},
+
+		// Aho-Corasick
+		{name: "keyword", globs: []string{"*paypal*"}, input: "login.paypal-secure.net", want: []int{0}},
+		{name: "keyword, no match", globs: []string{"*paypal*"}, input: "login.paypa1.net"},
+		{name: "prefix", globs: []string{"login*"}, input: "login.example.com", want: []int{0}},
+		{name: "prefix, in the middle", globs: []string{"login*"}, input: "www.login.example.com"},
+		{name: "glob suffix", globs: []string{"*example.com"}, input: "myexample.com", want: []int{0}},
+		{name: "glob suffix, in the middle", globs: []string{"*example.com"}, input: "example.com.evil"},
+		{name: "literal regexp", regexps: []string{`paypal`}, input: "paypal.example", want: []int{0}},
+		{name: "literal regexp with anchor", regexps: []string{`^paypal`}, input: "www.paypal.example"},
+		{name: "overlapping keywords", globs: []string{"*pay*", "*paypal*", "*pal*"}, input: "paypal", want: []int{0, 1, 2}},
+
+		// fallback
+		{name: "glob with class", globs: []string{"www[0-9].example.com"}, input: "www7.example.com", want: []int{0}},
+		{name: "glob with class, no match", globs: []string{"www[0-9].example.com"}, input: "wwwx.example.com"},
+		{name: "glob with alternatives", globs: []string{"{api,www}.example.com"}, input: "api.example.com", want: []int{0}},
+		{name: "regexp", regexps: []string{`^[a-z]+\.regexp\.io$`}, input: "abc.regexp.io", want: []int{0}},
+		{name: "regexp, no match", regexps: []string{`^[a-z]+\.regexp\.io$`}, input: "ab1.regexp.io"},
+		{name: "regexp without literal", regexps: []string{`^[0-9]+$`}, input: "1234", want: []int{0}},
+		{name: "case insensitive regexp", regexps: []string{`(?i)PAYPAL`}, input: "paypal.example", want: []int{0}},
+		{name: "match all", globs: []string{"*"}, input: "anything.example", want: []int{0}},
+
+		// several ids
+		{
+			name:    "all structures",
+			globs:   []string{"*.example.com", "*shop*", "www.shop.example.com", "w?w.*"},
+			regexps: []string{`\.example\.com$`, `^nomatch`},
+			input:   "www.shop.example.com",
+			want:    []int{0, 1, 2, 3, 4},
+		},
+		{name: "same pattern twice", globs: []string{"*.example.com", "*.example.com"}, input: "www.example.com", want: []int{0, 1}},
+		{name: "reported once", globs: []string{"*a*"}, input: "banana", want: []int{0}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := newMatcher(t, test.globs, test.regexps)
+			if got := m.Match(test.input); fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("Match(%q) = %v, want %v", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+// Keywords may use all byte values, the automaton needs one more class for
+// the bytes of no keyword.
+func TestAhoCorasickAllBytes(t *testing.T) {
+	var keywords []keyword
+	for b := 0; b < 256; b++ {
+		keywords = append(keywords, keyword{id: b, text: string([]byte{byte(b)})})
+	}
+	ac := newAhoCorasick(keywords)
+	for b := 0; b < 256; b++ {
+		input := string([]byte{'.', byte(b)})
+		found := map[int]bool{}
+		ac.match(input, func(id int) { found[id] = true })
+		if !found['.'] || !found[b] || len(found) > 2 {
+			t.Errorf("match(%q) found %v, want %d and %d", input, found, '.', b)
+		}
+	}
+}
+
+func TestMatchAgreesWithLinearScan(t *testing.T) {
+	l, m := build(t, 500)
+	for _, name := range names(20000, 500) {
+		if want, got := fmt.Sprint(l.match(name)), fmt.Sprint(m.Match(name)); want != got {
+			t.Errorf("%s: linear scan matched %s, matcher matched %s", name, want, got)
+		}
+	}
+}
+
+var sizes = []int{10, 100, 1000, 5000}
+
+func BenchmarkLinearScan(b *testing.B) {
+	for _, n := range sizes {
+		l, _ := build(b, n)
+		input := names(10000, n)
+		b.Run(fmt.Sprintf("patterns=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				l.match(input[i%len(input)])
+			}
+		})
+	}
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	for _, n := range sizes {
+		l, m := build(b, n)
+		input := names(10000, n)
+
+		// both implementations have to agree before their speed is compared
+		for _, name := range input {
+			want, got := fmt.Sprint(l.match(name)), fmt.Sprint(m.Match(name))
+			if want != got {
+				b.Fatalf("%s: linear scan matched %s, matcher matched %s", name, want, got)
+			}
+		}
+
+		b.Run(fmt.Sprintf("patterns=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				m.Match(input[i%len(input)])
+			}
+		})
+	}
+}
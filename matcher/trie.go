@@ -0,0 +1,69 @@
+package matcher
+
+// labelNode is a trie over the labels of a name, starting at the last one.
+type labelNode struct {
+	children map[string]*labelNode
+	exact    []int // the name ends at this node
+	sub      []int // the name has at least one more label after this node
+}
+
+func newLabelNode() *labelNode {
+	return &labelNode{children: map[string]*labelNode{}}
+}
+
+func (n *labelNode) insert(name string, id int, subdomains bool) {
+	node := n
+	end := len(name)
+	for {
+		start := lastDot(name[:end]) + 1
+		label := name[start:end]
+		child, ok := node.children[label]
+		if !ok {
+			child = newLabelNode()
+			node.children[label] = child
+		}
+		node = child
+		if start == 0 {
+			break
+		}
+		end = start - 1
+	}
+
+	if subdomains {
+		node.sub = append(node.sub, id)
+	} else {
+		node.exact = append(node.exact, id)
+	}
+}
+
+func (n *labelNode) match(name string, fn func(id int)) {
+	node := n
+	end := len(name)
+	for {
+		start := lastDot(name[:end]) + 1
+		child, ok := node.children[name[start:end]]
+		if !ok {
+			return
+		}
+		node = child
+		if start == 0 {
+			for _, id := range node.exact {
+				fn(id)
+			}
+			return
+		}
+		for _, id := range node.sub {
+			fn(id)
+		}
+		end = start - 1
+	}
+}
+
+func lastDot(s string) int {
+	for i := len(s) - 1; i >= 0; i-- {
+		if s[i] == '.' {
+			return i
+		}
+	}
+	return -1
+}
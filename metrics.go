@@ -45,3 +45,48 @@ var prometheusIterationCount = promauto.NewCounter(prometheus.CounterOpts{
 	Name: "certalert_iteration_count",
 	Help: "The amount of iterations",
 })
+
+var prometheusOutboxDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "certalert_outbox_deliveries_total",
+	Help: "The number of notifications delivered from the outbox",
+}, []string{"service", "tenant"})
+
+var prometheusOutboxFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "certalert_outbox_delivery_failures_total",
+	Help: "The number of failed notification delivery attempts",
+}, []string{"service", "tenant"})
+
+var prometheusOutboxDeadLetters = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "certalert_outbox_dead_letters_total",
+	Help: "The number of notifications given up on after too many attempts",
+}, []string{"service", "tenant"})
+
+var prometheusNotificationsSuppressed = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "certalert_notifications_suppressed_total",
+	Help: "The number of notifications held back by a rate limit or quiet hours",
+}, []string{"service", "tenant", "reason", "policy"})
+
+var prometheusWatcherMatches = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "certalert_watcher_matches_total",
+	Help: "The number of certificates matched per watcher",
+}, []string{"tenant", "watcher"})
+
+var prometheusDispatchDropped = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "certalert_dispatch_dropped_total",
+	Help: "The number of matched certificates dropped because the dispatch buffer was full",
+})
+
+var prometheusDispatchBlocked = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "certalert_dispatch_blocked_seconds_total",
+	Help: "The time log ingestion spent waiting for space in the dispatch buffer",
+})
+
+var prometheusConfigReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "certalert_config_reloads_total",
+	Help: "The number of configuration reloads by trigger and outcome",
+}, []string{"trigger", "outcome"})
+
+var prometheusConfigLastReload = promauto.NewGauge(prometheus.GaugeOpts{
+	Name: "certalert_config_last_reload_timestamp_seconds",
+	Help: "When a changed configuration was last applied",
+})
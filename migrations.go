@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresMigrations are applied in order and recorded in schema_migrations.
+// Never change a migration that was released, append a new one instead.
+var postgresMigrations = []string{
+	// 1: outbox, created with IF NOT EXISTS as it predates the migrations
+	`CREATE TABLE IF NOT EXISTS certalert_outbox (
+		id           TEXT PRIMARY KEY,
+		target       JSONB NOT NULL,
+		title        TEXT NOT NULL,
+		message      TEXT NOT NULL,
+		body         BYTEA,
+		attempts     INTEGER NOT NULL DEFAULT 0,
+		next_attempt TIMESTAMPTZ NOT NULL,
+		last_error   TEXT NOT NULL DEFAULT '',
+		created_at   TIMESTAMPTZ NOT NULL,
+		dead         BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+
+	// 2: match history
+	`CREATE TABLE certalert_matches (
+		id                  BIGSERIAL PRIMARY KEY,
+		fingerprint         TEXT NOT NULL,
+		spki_sha256         TEXT NOT NULL,
+		serial              TEXT NOT NULL,
+		common_name         TEXT NOT NULL,
+		names               TEXT[] NOT NULL,
+		matched_names       TEXT[] NOT NULL,
+		issuer              TEXT NOT NULL,
+		issuer_common_name  TEXT NOT NULL,
+		issuer_organization TEXT NOT NULL,
+		not_before          TIMESTAMPTZ NOT NULL,
+		not_after           TIMESTAMPTZ NOT NULL,
+		precert             BOOLEAN NOT NULL,
+		log_id              TEXT NOT NULL,
+		log_description     TEXT NOT NULL,
+		log_url             TEXT NOT NULL,
+		log_index           BIGINT NOT NULL,
+		watcher             TEXT NOT NULL,
+		severity            TEXT NOT NULL,
+		raw                 BYTEA NOT NULL,
+		seen_at             TIMESTAMPTZ NOT NULL,
+		notified_at         TIMESTAMPTZ,
+		UNIQUE (fingerprint, watcher)
+	);
+	CREATE INDEX certalert_matches_seen_at ON certalert_matches (seen_at);
+	CREATE INDEX certalert_matches_names ON certalert_matches USING GIN (names);`,
+
+	// 3: link deliveries to the matches they notify about
+	`ALTER TABLE certalert_outbox ADD COLUMN match_ids BIGINT[] NOT NULL DEFAULT '{}'`,
+
+	// 4: state surviving restarts and the name inventory
+	`CREATE TABLE certalert_checkpoints (
+		log_id     TEXT PRIMARY KEY,
+		tree_size  BIGINT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE certalert_seen (
+		key     TEXT PRIMARY KEY,
+		seen_at TIMESTAMPTZ NOT NULL
+	);
+	CREATE TABLE certalert_names (
+		name         TEXT PRIMARY KEY,
+		base_domain  TEXT NOT NULL,
+		first_seen   TIMESTAMPTZ NOT NULL,
+		last_seen    TIMESTAMPTZ NOT NULL,
+		certificates BIGINT NOT NULL DEFAULT 1
+	);
+	CREATE INDEX certalert_names_base_domain ON certalert_names (base_domain);`,
+
+	// 5: watchers managed through the API
+	`CREATE TABLE certalert_watchers (
+		id         TEXT PRIMARY KEY,
+		config     TEXT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL
+	)`,
+
+	// 6: tenants, the same certificate and names may be recorded once per tenant
+	`ALTER TABLE certalert_matches ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+	ALTER TABLE certalert_matches DROP CONSTRAINT certalert_matches_fingerprint_watcher_key;
+	ALTER TABLE certalert_matches ADD UNIQUE (fingerprint, tenant, watcher);
+	ALTER TABLE certalert_names ADD COLUMN tenant TEXT NOT NULL DEFAULT '';
+	ALTER TABLE certalert_names DROP CONSTRAINT certalert_names_pkey;
+	ALTER TABLE certalert_names ADD PRIMARY KEY (tenant, name);
+	DROP INDEX certalert_names_base_domain;
+	CREATE INDEX certalert_names_base_domain ON certalert_names (tenant, base_domain);`,
+}
+
+// migratePostgres brings the schema up to date. It is safe to run from
+// several processes at once.
+func migratePostgres(ctx context.Context, pool *pgxpool.Pool) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// serializes concurrent migrations, the key is arbitrary but fixed
+	if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(7305513362478)`); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := tx.QueryRow(ctx, `SELECT coalesce(max(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	if current > len(postgresMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this version of certalert (%d)", current, len(postgresMigrations))
+	}
+
+	for i := current; i < len(postgresMigrations); i++ {
+		if _, err := tx.Exec(ctx, postgresMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, i+1); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// sqliteMigrations mirror postgresMigrations for the embedded store. Times
+// are unix milliseconds and lists are JSON arrays.
+var sqliteMigrations = []string{
+	// 1: match history
+	`CREATE TABLE certalert_matches (
+		id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+		fingerprint         TEXT NOT NULL,
+		spki_sha256         TEXT NOT NULL,
+		serial              TEXT NOT NULL,
+		common_name         TEXT NOT NULL,
+		names               TEXT NOT NULL,
+		matched_names       TEXT NOT NULL,
+		issuer              TEXT NOT NULL,
+		issuer_common_name  TEXT NOT NULL,
+		issuer_organization TEXT NOT NULL,
+		not_before          INTEGER NOT NULL,
+		not_after           INTEGER NOT NULL,
+		precert             INTEGER NOT NULL,
+		log_id              TEXT NOT NULL,
+		log_description     TEXT NOT NULL,
+		log_url             TEXT NOT NULL,
+		log_index           INTEGER NOT NULL,
+		watcher             TEXT NOT NULL,
+		severity            TEXT NOT NULL,
+		raw                 BLOB NOT NULL,
+		seen_at             INTEGER NOT NULL,
+		notified_at         INTEGER,
+		UNIQUE (fingerprint, watcher)
+	);
+	CREATE INDEX certalert_matches_seen_at ON certalert_matches (seen_at);`,
+
+	// 2: state surviving restarts and the name inventory
+	`CREATE TABLE certalert_checkpoints (
+		log_id     TEXT PRIMARY KEY,
+		tree_size  INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+	CREATE TABLE certalert_seen (
+		key     TEXT PRIMARY KEY,
+		seen_at INTEGER NOT NULL
+	);
+	CREATE TABLE certalert_names (
+		name         TEXT PRIMARY KEY,
+		base_domain  TEXT NOT NULL,
+		first_seen   INTEGER NOT NULL,
+		last_seen    INTEGER NOT NULL,
+		certificates INTEGER NOT NULL DEFAULT 1
+	);
+	CREATE INDEX certalert_names_base_domain ON certalert_names (base_domain);`,
+
+	// 3: watchers managed through the API
+	`CREATE TABLE certalert_watchers (
+		id         TEXT PRIMARY KEY,
+		config     TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	)`,
+
+	// 4: tenants, sqlite can't change constraints so the tables are rebuilt
+	`CREATE TABLE certalert_matches_new (
+		id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+		fingerprint         TEXT NOT NULL,
+		spki_sha256         TEXT NOT NULL,
+		serial              TEXT NOT NULL,
+		common_name         TEXT NOT NULL,
+		names               TEXT NOT NULL,
+		matched_names       TEXT NOT NULL,
+		issuer              TEXT NOT NULL,
+		issuer_common_name  TEXT NOT NULL,
+		issuer_organization TEXT NOT NULL,
+		not_before          INTEGER NOT NULL,
+		not_after           INTEGER NOT NULL,
+		precert             INTEGER NOT NULL,
+		log_id              TEXT NOT NULL,
+		log_description     TEXT NOT NULL,
+		log_url             TEXT NOT NULL,
+		log_index           INTEGER NOT NULL,
+		watcher             TEXT NOT NULL,
+		tenant              TEXT NOT NULL DEFAULT '',
+		severity            TEXT NOT NULL,
+		raw                 BLOB NOT NULL,
+		seen_at             INTEGER NOT NULL,
+		notified_at         INTEGER,
+		UNIQUE (fingerprint, tenant, watcher)
+	);
+	INSERT INTO certalert_matches_new (
+		id, fingerprint, spki_sha256, serial, common_name, names, matched_names,
+		issuer, issuer_common_name, issuer_organization, not_before, not_after, precert,
+		log_id, log_description, log_url, log_index, watcher, severity, raw, seen_at, notified_at
+	) SELECT
+		id, fingerprint, spki_sha256, serial, common_name, names, matched_names,
+		issuer, issuer_common_name, issuer_organization, not_before, not_after, precert,
+		log_id, log_description, log_url, log_index, watcher, severity, raw, seen_at, notified_at
+	FROM certalert_matches;
+	DROP TABLE certalert_matches;
+	ALTER TABLE certalert_matches_new RENAME TO certalert_matches;
+	CREATE INDEX certalert_matches_seen_at ON certalert_matches (seen_at);
+
+	CREATE TABLE certalert_names_new (
+		tenant       TEXT NOT NULL DEFAULT '',
+		name         TEXT NOT NULL,
+		base_domain  TEXT NOT NULL,
+		first_seen   INTEGER NOT NULL,
+		last_seen    INTEGER NOT NULL,
+		certificates INTEGER NOT NULL DEFAULT 1,
+		PRIMARY KEY (tenant, name)
+	);
+	INSERT INTO certalert_names_new (name, base_domain, first_seen, last_seen, certificates)
+		SELECT name, base_domain, first_seen, last_seen, certificates FROM certalert_names;
+	DROP TABLE certalert_names;
+	ALTER TABLE certalert_names_new RENAME TO certalert_names;
+	CREATE INDEX certalert_names_base_domain ON certalert_names (tenant, base_domain);`,
+}
+
+func migrateSQLite(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    INTEGER PRIMARY KEY,
+		applied_at INTEGER NOT NULL
+	)`); err != nil {
+		return err
+	}
+
+	var current int
+	if err := tx.QueryRowContext(ctx, `SELECT coalesce(max(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	if current > len(sqliteMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this version of certalert (%d)", current, len(sqliteMigrations))
+	}
+
+	for i := current; i < len(sqliteMigrations); i++ {
+		if _, err := tx.ExecContext(ctx, sqliteMigrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)`, i+1, time.Now().UnixMilli()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
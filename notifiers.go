@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// path names the top-level notifier in errors.
+func (n *NotifierConfig) path(i int) string {
+	path := fmt.Sprintf("notifier[%d]", i)
+	if n.Name != "" {
+		path = fmt.Sprintf("notifier[%s]", n.Name)
+	}
+	if n.tenant != "" {
+		path = fmt.Sprintf("tenant[%s].%s", n.tenant, path)
+	}
+	if n.source != "" {
+		path = n.source + ": " + path
+	}
+	return path
+}
+
+// notifierKey identifies a named notifier in Config.notifiers. Notifiers
+// shared by all tenants have no tenant.
+func notifierKey(tenant string, name string) string {
+	return tenant + "/" + name
+}
+
+// prepareNotifiers validates the named notifiers once, so that watchers
+// referring to one don't repeat its problems and its URL is only checked once.
+func (cfg *Config) prepareNotifiers() []error {
+	cfg.notifiers = map[string]*NotifierConfig{}
+	errs := cfg.prepareNamedNotifiers(cfg.Notifiers, "")
+	for i := range cfg.Tenants {
+		// invalid tenants were reported already
+		if t := &cfg.Tenants[i]; cfg.tenants[t.Name] == t {
+			errs = append(errs, cfg.prepareNamedNotifiers(t.Notifiers, t.Name)...)
+		}
+	}
+	return errs
+}
+
+// prepareNamedNotifiers validates the top-level notifiers, if tenant is
+// empty, or those of the tenant.
+func (cfg *Config) prepareNamedNotifiers(notifiers []NotifierConfig, tenant string) []error {
+	var errs []error
+	defined := map[string]bool{}
+	for i := range notifiers {
+		n := &notifiers[i]
+		n.tenant = tenant
+		switch {
+		case !tenantName.MatchString(n.Name):
+			errs = append(errs, fmt.Errorf("%s: name %q must consist of lowercase letters, digits, '-' and '_'", n.path(i), n.Name))
+			continue
+		case defined[n.Name]:
+			errs = append(errs, fmt.Errorf("%s: name %q is used twice", n.path(i), n.Name))
+			continue
+		case n.Notifier != "":
+			errs = append(errs, fmt.Errorf("%s: can't refer to another notifier", n.path(i)))
+			continue
+		}
+		defined[n.Name] = true
+
+		// templates are checked with the data of a watcher without name,
+		// and again for each watcher that refers to the notifier
+		if err := cfg.prepareNotifier(&WatcherConfig{Tenant: tenant}, n, n.path(i)); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cfg.notifiers[notifierKey(tenant, n.Name)] = n
+	}
+	return errs
+}
+
+// resolveNotifier replaces a reference with the named notifier it refers to:
+// one of the tenant's, or else one shared by all tenants. It returns nil if
+// the notifier doesn't exist or is invalid, in which case the error was
+// reported already.
+func (cfg *Config) resolveNotifier(n *NotifierConfig, path string, tenant string) (*NotifierConfig, error) {
+	if !reflect.DeepEqual(*n, NotifierConfig{Notifier: n.Notifier}) {
+		return nil, fmt.Errorf("%s: a reference to notifier %q can't set other options", path, n.Notifier)
+	}
+	named := cfg.notifiers[notifierKey(tenant, n.Notifier)]
+	if named == nil {
+		named = cfg.notifiers[notifierKey("", n.Notifier)]
+	}
+	if named == nil {
+		defined := func(notifiers []NotifierConfig) bool {
+			for i := range notifiers {
+				if notifiers[i].Name == n.Notifier {
+					return true
+				}
+			}
+			return false
+		}
+		if defined(cfg.Notifiers) || (cfg.tenants[tenant] != nil && defined(cfg.tenants[tenant].Notifiers)) {
+			return nil, nil
+		}
+		for _, t := range cfg.Tenants {
+			if t.Name != tenant && defined(t.Notifiers) {
+				return nil, fmt.Errorf("%s: notifier %q belongs to tenant %q", path, n.Notifier, t.Name)
+			}
+		}
+		return nil, fmt.Errorf("%s: unknown notifier %q", path, n.Notifier)
+	}
+
+	// the templates are parsed again with the watcher's fallbacks
+	*n = *named
+	n.Notifier, n.source = named.Name, ""
+	return named, nil
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/containrrr/shoutrrr"
+	"github.com/containrrr/shoutrrr/pkg/router"
+	"github.com/containrrr/shoutrrr/pkg/types"
+)
+
+type OutboxConfig struct {
+	Type           string   `yaml:"type"`        // memory (default), file or postgres
+	Path           string   `yaml:"path"`        // directory of the file queue
+	PostgresURL    string   `yaml:"postgresURL"` // connection string of the postgres queue
+	MaxAttempts    int      `yaml:"maxAttempts"`
+	InitialBackoff Duration `yaml:"initialBackoff"`
+	MaxBackoff     Duration `yaml:"maxBackoff"`
+}
+
+// DeliveryTarget is where a delivery is sent to. It is a copy of the
+// notifier, so pending deliveries survive configuration changes. This means
+// the persisted outbox contains the notifier URLs and webhook secrets, so the
+// file queue is only readable by its owner.
+type DeliveryTarget struct {
+	ShoutrrrURL string         `json:"shoutrrrURL,omitempty"`
+	Webhook     *WebhookConfig `json:"webhook,omitempty"`
+	Workers     int            `json:"workers,omitempty"` // concurrent sends, 1 by default
+	Tenant      string         `json:"tenant,omitempty"`
+}
+
+// key identifies the target for rate limits and concurrency, which are
+// separate per tenant even if tenants notify the same service.
+func (t DeliveryTarget) key() string {
+	key := t.ShoutrrrURL
+	if t.Webhook != nil {
+		key = "webhook:" + t.Webhook.URL
+	}
+	if t.Tenant != "" {
+		key = t.Tenant + "|" + key
+	}
+	return key
+}
+
+// service is used as metric label, as the URLs themselves contain secrets.
+func (t DeliveryTarget) service() string {
+	if t.Webhook != nil {
+		return "webhook"
+	}
+	if u, err := url.Parse(t.ShoutrrrURL); err == nil && u.Scheme != "" {
+		return u.Scheme
+	}
+	return "unknown"
+}
+
+// Delivery is a rendered notification waiting to be sent.
+type Delivery struct {
+	ID          string         `json:"id"`
+	Target      DeliveryTarget `json:"target"`
+	Title       string         `json:"title"`
+	Message     string         `json:"message"`
+	Body        []byte         `json:"body,omitempty"` // JSON document for webhooks
+	Attempts    int            `json:"attempts"`
+	NextAttempt time.Time      `json:"nextAttempt"`
+	LastError   string         `json:"lastError,omitempty"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	MatchIDs    []int64        `json:"matchIds,omitempty"` // stored matches this delivery notifies about
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// outboxQueue persists deliveries until they were sent or dead-lettered.
+type outboxQueue interface {
+	Add(d *Delivery) error
+	Pending() ([]*Delivery, error) // oldest first
+	Update(d *Delivery) error
+	Remove(id string) error
+	DeadLetter(d *Delivery) error
+	Depth() int
+	Close() error
+}
+
+type targetState struct {
+	failures    int
+	nextAttempt time.Time
+	sending     int // deliveries currently being sent
+}
+
+// Outbox sends deliveries and retries failed ones with an exponential backoff
+// per target, so a notification service outage doesn't lose alerts.
+type Outbox struct {
+	queue  outboxQueue
+	config OutboxConfig
+
+	wake chan struct{}
+
+	mutex    sync.Mutex
+	targets  map[string]*targetState
+	senders  map[string]*router.ServiceRouter
+	inflight map[string]bool // ids of deliveries currently being sent
+	sending  sync.WaitGroup
+
+	delivered func(d *Delivery) // called after a delivery was sent
+}
+
+func NewOutbox(c OutboxConfig) (*Outbox, error) {
+	if c.MaxAttempts == 0 {
+		c.MaxAttempts = 10
+	}
+	if c.InitialBackoff.Duration == 0 {
+		c.InitialBackoff.Duration = 10 * time.Second
+	}
+	if c.MaxBackoff.Duration == 0 {
+		c.MaxBackoff.Duration = time.Hour
+	}
+
+	var queue outboxQueue
+	var err error
+	switch c.Type {
+	case "", "memory":
+		queue = newMemoryQueue()
+	case "file":
+		if c.Path == "" {
+			c.Path = "config/outbox"
+		}
+		queue, err = newFileQueue(c.Path)
+	case "postgres":
+		queue, err = newPostgresQueue(c.PostgresURL)
+	default:
+		err = fmt.Errorf("unknown outbox type %q (expected memory, file or postgres)", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Outbox{
+		queue:    queue,
+		config:   c,
+		wake:     make(chan struct{}, 1),
+		targets:  map[string]*targetState{},
+		senders:  map[string]*router.ServiceRouter{},
+		inflight: map[string]bool{},
+	}, nil
+}
+
+// Enqueue persists the delivery and wakes up the delivery loop.
+func (o *Outbox) Enqueue(d *Delivery) error {
+	return o.EnqueueAt(d, time.Now())
+}
+
+// EnqueueAt adds a delivery that is not attempted before the given time.
+func (o *Outbox) EnqueueAt(d *Delivery, at time.Time) error {
+	d.ID = newDeliveryID()
+	d.CreatedAt = time.Now()
+	d.NextAttempt = at
+	if err := o.queue.Add(d); err != nil {
+		return err
+	}
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// OnDelivered registers a function that is called after each successful
+// delivery. It must be called before Run.
+func (o *Outbox) OnDelivered(fn func(d *Delivery)) {
+	o.delivered = fn
+}
+
+// Persistent reports whether pending deliveries survive a restart.
+func (o *Outbox) Persistent() bool {
+	return o.config.Type == "file" || o.config.Type == "postgres"
+}
+
+func (o *Outbox) Depth() int {
+	return o.queue.Depth()
+}
+
+// Run delivers pending deliveries until the context is cancelled.
+func (o *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		o.deliverDue()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-o.wake:
+		}
+	}
+}
+
+// Drain attempts every due delivery once more and waits for the attempts to
+// finish. Deliveries that are backing off are left in the queue.
+func (o *Outbox) Drain(ctx context.Context) error {
+	o.deliverDue()
+	return o.waitSending(ctx)
+}
+
+func (o *Outbox) waitSending(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		o.sending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (o *Outbox) backoff(failures int) time.Duration {
+	backoff := float64(o.config.InitialBackoff.Duration) * math.Pow(2, float64(failures-1))
+	if backoff > float64(o.config.MaxBackoff.Duration) {
+		return o.config.MaxBackoff.Duration
+	}
+	return time.Duration(backoff)
+}
+
+func (o *Outbox) deliverDue() {
+	pending, err := o.queue.Pending()
+	if err != nil {
+		logln("failed to read outbox:", err.Error())
+		return
+	}
+
+	now := time.Now()
+	for _, d := range pending {
+		if d.NextAttempt.After(now) {
+			continue
+		}
+		key := d.Target.key()
+		workers := d.Target.Workers
+		if workers < 1 {
+			workers = 1
+		}
+
+		o.mutex.Lock()
+		state, ok := o.targets[key]
+		if !ok {
+			state = &targetState{}
+			o.targets[key] = state
+		}
+		// a slow or backing off target must not hold up the others
+		if o.inflight[d.ID] || state.sending >= workers || state.nextAttempt.After(now) {
+			o.mutex.Unlock()
+			continue
+		}
+		o.inflight[d.ID] = true
+		state.sending++
+		o.mutex.Unlock()
+
+		o.sending.Add(1)
+		go func(d *Delivery, state *targetState) {
+			defer o.sending.Done()
+			o.attempt(d, state)
+
+			o.mutex.Lock()
+			delete(o.inflight, d.ID)
+			state.sending--
+			o.mutex.Unlock()
+
+			select {
+			case o.wake <- struct{}{}:
+			default:
+			}
+		}(d, state)
+	}
+}
+
+func (o *Outbox) attempt(d *Delivery, state *targetState) {
+	service := d.Target.service()
+
+	err := o.send(d)
+	if err == nil {
+		o.mutex.Lock()
+		state.failures = 0
+		state.nextAttempt = time.Time{}
+		o.mutex.Unlock()
+
+		prometheusOutboxDeliveries.WithLabelValues(service, d.Target.Tenant).Inc()
+		if err := o.queue.Remove(d.ID); err != nil {
+			logln("failed to remove delivery from outbox:", err.Error())
+		}
+		if o.delivered != nil {
+			o.delivered(d)
+		}
+		return
+	}
+
+	prometheusOutboxFailures.WithLabelValues(service, d.Target.Tenant).Inc()
+
+	o.mutex.Lock()
+	state.failures++
+	backoff := o.backoff(state.failures)
+	state.nextAttempt = time.Now().Add(backoff)
+	o.mutex.Unlock()
+
+	d.Attempts++
+	// e.g. errors of HTTP requests contain the URL
+	d.LastError = redactSecrets(err.Error())
+	d.NextAttempt = time.Now().Add(backoff)
+
+	if d.Attempts >= o.config.MaxAttempts {
+		logf("giving up on notification via %s after %d attempts: %s\n", service, d.Attempts, d.LastError)
+		prometheusOutboxDeadLetters.WithLabelValues(service, d.Target.Tenant).Inc()
+		if err := o.queue.DeadLetter(d); err != nil {
+			logln("failed to dead-letter delivery:", err.Error())
+		}
+		return
+	}
+
+	logf("failed to notify via %s (attempt %d, retrying in %s): %s\n", service, d.Attempts, backoff, d.LastError)
+	if err := o.queue.Update(d); err != nil {
+		logln("failed to update delivery in outbox:", err.Error())
+	}
+}
+
+func (o *Outbox) send(d *Delivery) error {
+	if d.Target.Webhook != nil {
+		return d.Target.Webhook.Send(d.Body)
+	}
+
+	o.mutex.Lock()
+	sender, ok := o.senders[d.Target.ShoutrrrURL]
+	if !ok {
+		var err error
+		sender, err = shoutrrr.NewSender(log.Default(), d.Target.ShoutrrrURL)
+		if err != nil {
+			o.mutex.Unlock()
+			return fmt.Errorf("unable to create sender: %s", err.Error())
+		}
+		o.senders[d.Target.ShoutrrrURL] = sender
+	}
+	o.mutex.Unlock()
+
+	for _, err := range sender.Send(d.Message, &types.Params{"title": d.Title}) {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close waits for the attempts in progress and closes the queue. If the
+// context is done first, the queue is left open for the attempts to finish.
+func (o *Outbox) Close(ctx context.Context) error {
+	if err := o.waitSending(ctx); err != nil {
+		return err
+	}
+	return o.queue.Close()
+}
+
+type memoryQueue struct {
+	mutex      sync.Mutex
+	deliveries map[string]*Delivery
+}
+
+func newMemoryQueue() *memoryQueue {
+	return &memoryQueue{deliveries: map[string]*Delivery{}}
+}
+
+// Add keeps a copy of the delivery, and Pending returns copies, so that a
+// delivery being attempted is never read by the delivery loop at the same time.
+func (q *memoryQueue) Add(d *Delivery) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	copied := *d
+	q.deliveries[d.ID] = &copied
+	return nil
+}
+
+func (q *memoryQueue) Pending() ([]*Delivery, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	out := make([]*Delivery, 0, len(q.deliveries))
+	for _, d := range q.deliveries {
+		copied := *d
+		out = append(out, &copied)
+	}
+	sortDeliveries(out)
+	return out, nil
+}
+
+func (q *memoryQueue) Update(d *Delivery) error {
+	return q.Add(d)
+}
+
+func (q *memoryQueue) Remove(id string) error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.deliveries, id)
+	return nil
+}
+
+func (q *memoryQueue) DeadLetter(d *Delivery) error {
+	return q.Remove(d.ID)
+}
+
+func (q *memoryQueue) Depth() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.deliveries)
+}
+
+func (q *memoryQueue) Close() error {
+	return nil
+}
+
+func sortDeliveries(deliveries []*Delivery) {
+	sort.Slice(deliveries, func(i, j int) bool {
+		if !deliveries[i].CreatedAt.Equal(deliveries[j].CreatedAt) {
+			return deliveries[i].CreatedAt.Before(deliveries[j].CreatedAt)
+		}
+		return deliveries[i].ID < deliveries[j].ID
+	})
+}
+
+// fileQueue keeps one JSON file per delivery in <path>/pending and moves
+// dead-lettered deliveries to <path>/dead. The files are the source of truth,
+// the in memory copy only saves reading them on every iteration.
+type fileQueue struct {
+	memoryQueue
+	path string
+}
+
+func newFileQueue(path string) (*fileQueue, error) {
+	for _, dir := range []string{"", "pending", "dead"} {
+		if err := os.MkdirAll(filepath.Join(path, dir), 0o700); err != nil {
+			return nil, fmt.Errorf("create outbox directory: %w", err)
+		}
+		// the deliveries contain secrets, see DeliveryTarget
+		if err := os.Chmod(filepath.Join(path, dir), 0o700); err != nil {
+			return nil, fmt.Errorf("create outbox directory: %w", err)
+		}
+	}
+
+	q := &fileQueue{memoryQueue: memoryQueue{deliveries: map[string]*Delivery{}}, path: path}
+
+	files, err := os.ReadDir(filepath.Join(path, "pending"))
+	if err != nil {
+		return nil, fmt.Errorf("read outbox directory: %w", err)
+	}
+	for _, file := range files {
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(path, "pending", file.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read outbox file: %w", err)
+		}
+		d := &Delivery{}
+		if err := json.Unmarshal(b, d); err != nil {
+			logf("skipping broken outbox file %s: %s\n", file.Name(), err.Error())
+			continue
+		}
+		q.deliveries[d.ID] = d
+	}
+	if len(q.deliveries) > 0 {
+		logln("loaded", len(q.deliveries), "pending notifications from the outbox")
+	}
+
+	return q, nil
+}
+
+func (q *fileQueue) write(dir string, d *Delivery) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	// write and rename, so that a crash never leaves a half written file
+	tmp := filepath.Join(q.path, dir, d.ID+".json.tmp")
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(q.path, dir, d.ID+".json"))
+}
+
+func (q *fileQueue) Add(d *Delivery) error {
+	if err := q.write("pending", d); err != nil {
+		return err
+	}
+	return q.memoryQueue.Add(d)
+}
+
+func (q *fileQueue) Update(d *Delivery) error {
+	return q.Add(d)
+}
+
+func (q *fileQueue) Remove(id string) error {
+	if err := os.Remove(filepath.Join(q.path, "pending", id+".json")); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return q.memoryQueue.Remove(id)
+}
+
+func (q *fileQueue) DeadLetter(d *Delivery) error {
+	if err := q.write("dead", d); err != nil {
+		return err
+	}
+	return q.Remove(d.ID)
+}
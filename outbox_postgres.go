@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresQueue keeps the outbox in the certalert_outbox table.
+type postgresQueue struct {
+	pool *pgxpool.Pool
+}
+
+func newPostgresQueue(connString string) (*postgresQueue, error) {
+	if connString == "" {
+		return nil, fmt.Errorf("postgresURL is required for the postgres outbox")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	if err := migratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("migrate database: %w", err)
+	}
+
+	return &postgresQueue{pool: pool}, nil
+}
+
+func (q *postgresQueue) Add(d *Delivery) error {
+	target, err := json.Marshal(d.Target)
+	if err != nil {
+		return err
+	}
+	_, err = q.pool.Exec(context.Background(), `
+		INSERT INTO certalert_outbox (id, target, title, message, body, attempts, next_attempt, last_error, created_at, match_ids)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, coalesce($10::bigint[], '{}'))`,
+		d.ID, target, d.Title, d.Message, d.Body, d.Attempts, d.NextAttempt, d.LastError, d.CreatedAt, d.MatchIDs)
+	return err
+}
+
+func (q *postgresQueue) Pending() ([]*Delivery, error) {
+	rows, err := q.pool.Query(context.Background(), `
+		SELECT id, target, title, message, body, attempts, next_attempt, last_error, created_at, match_ids
+		FROM certalert_outbox
+		WHERE NOT dead
+		ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*Delivery
+	for rows.Next() {
+		d := &Delivery{}
+		var target []byte
+		if err := rows.Scan(&d.ID, &target, &d.Title, &d.Message, &d.Body, &d.Attempts, &d.NextAttempt, &d.LastError, &d.CreatedAt, &d.MatchIDs); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(target, &d.Target); err != nil {
+			return nil, fmt.Errorf("delivery %s: %w", d.ID, err)
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+func (q *postgresQueue) Update(d *Delivery) error {
+	_, err := q.pool.Exec(context.Background(), `
+		UPDATE certalert_outbox SET attempts = $2, next_attempt = $3, last_error = $4 WHERE id = $1`,
+		d.ID, d.Attempts, d.NextAttempt, d.LastError)
+	return err
+}
+
+func (q *postgresQueue) Remove(id string) error {
+	_, err := q.pool.Exec(context.Background(), `DELETE FROM certalert_outbox WHERE id = $1`, id)
+	return err
+}
+
+func (q *postgresQueue) DeadLetter(d *Delivery) error {
+	_, err := q.pool.Exec(context.Background(), `
+		UPDATE certalert_outbox SET attempts = $2, last_error = $3, dead = TRUE WHERE id = $1`,
+		d.ID, d.Attempts, d.LastError)
+	return err
+}
+
+func (q *postgresQueue) Depth() int {
+	var depth int
+	if err := q.pool.QueryRow(context.Background(), `SELECT count(*) FROM certalert_outbox WHERE NOT dead`).Scan(&depth); err != nil {
+		return 0
+	}
+	return depth
+}
+
+func (q *postgresQueue) Close() error {
+	q.pool.Close()
+	return nil
+}
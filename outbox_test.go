@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// outboxTestServer is a webhook answering with a status that can be changed.
+type outboxTestServer struct {
+	*httptest.Server
+	status   atomic.Int32
+	requests atomic.Int32
+}
+
+func startOutboxTestServer(t *testing.T, status int) *outboxTestServer {
+	t.Helper()
+	s := &outboxTestServer{}
+	s.status.Store(int32(status))
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		w.WriteHeader(int(s.status.Load()))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *outboxTestServer) delivery() *Delivery {
+	return &Delivery{Target: DeliveryTarget{Webhook: &WebhookConfig{URL: s.URL}}, Title: "title", Body: []byte(`{}`)}
+}
+
+func pendingDeliveries(t *testing.T, o *Outbox) []*Delivery {
+	t.Helper()
+	pending, err := o.queue.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pending
+}
+
+func drainOutbox(t *testing.T, o *Outbox) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := o.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	o, err := NewOutbox(OutboxConfig{
+		InitialBackoff: Duration{Duration: 10 * time.Second},
+		MaxBackoff:     Duration{Duration: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[int]time.Duration{
+		1:  10 * time.Second,
+		2:  20 * time.Second,
+		3:  40 * time.Second,
+		4:  time.Minute,
+		20: time.Minute,
+	}
+	for failures, want := range tests {
+		if got := o.backoff(failures); got != want {
+			t.Errorf("backoff(%d) = %s, want %s", failures, got, want)
+		}
+	}
+}
+
+func TestOutboxRetries(t *testing.T) {
+	for _, queue := range []string{"memory", "file"} {
+		t.Run(queue, func(t *testing.T) {
+			server := startOutboxTestServer(t, http.StatusInternalServerError)
+			dir := t.TempDir()
+			o, err := NewOutbox(OutboxConfig{
+				Type:           queue,
+				Path:           dir,
+				MaxAttempts:    3,
+				InitialBackoff: Duration{Duration: time.Hour},
+				MaxBackoff:     Duration{Duration: 4 * time.Hour},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := o.Enqueue(server.delivery()); err != nil {
+				t.Fatal(err)
+			}
+
+			drainOutbox(t, o)
+			pending := pendingDeliveries(t, o)
+			if len(pending) != 1 {
+				t.Fatalf("got %d pending deliveries after a failure, want 1", len(pending))
+			}
+			d := pending[0]
+			if d.Attempts != 1 || d.LastError == "" {
+				t.Errorf("got %d attempts with error %q, want 1 attempt with an error", d.Attempts, d.LastError)
+			}
+			if wait := time.Until(d.NextAttempt); wait < 59*time.Minute || wait > time.Hour {
+				t.Errorf("next attempt in %s, want the initial backoff of 1h", wait)
+			}
+
+			// backing off
+			drainOutbox(t, o)
+			if got := server.requests.Load(); got != 1 {
+				t.Fatalf("got %d requests while backing off, want 1", got)
+			}
+
+			// made due again, the backoff doubles until the attempts run out
+			for attempts := 2; attempts <= 3; attempts++ {
+				d.NextAttempt = time.Now()
+				if err := o.queue.Update(d); err != nil {
+					t.Fatal(err)
+				}
+				o.targets[d.Target.key()].nextAttempt = time.Time{}
+				drainOutbox(t, o)
+				if attempts == 3 {
+					break
+				}
+
+				pending := pendingDeliveries(t, o)
+				if len(pending) != 1 {
+					t.Fatalf("got %d pending deliveries after %d attempts, want 1", len(pending), attempts)
+				}
+				d = pending[0]
+				if wait := time.Until(d.NextAttempt); d.Attempts != attempts || wait < 119*time.Minute || wait > 2*time.Hour {
+					t.Errorf("got attempt %d in %s, want attempt %d in 2h", d.Attempts, wait, attempts)
+				}
+			}
+			if got := server.requests.Load(); got != 3 {
+				t.Errorf("got %d requests, want 3", got)
+			}
+			if pending := pendingDeliveries(t, o); len(pending) != 0 {
+				t.Errorf("got %d pending deliveries after the last attempt, want 0", len(pending))
+			}
+
+			if queue == "file" {
+				if _, err := os.Stat(filepath.Join(dir, "dead", d.ID+".json")); err != nil {
+					t.Errorf("the delivery was not dead-lettered: %v", err)
+				}
+				if _, err := os.Stat(filepath.Join(dir, "pending", d.ID+".json")); !os.IsNotExist(err) {
+					t.Errorf("the dead-lettered delivery is still pending: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestFileOutboxRestart(t *testing.T) {
+	server := startOutboxTestServer(t, http.StatusInternalServerError)
+	dir := t.TempDir()
+	config := OutboxConfig{Type: "file", Path: dir, InitialBackoff: Duration{Duration: time.Millisecond}}
+
+	o, err := NewOutbox(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := o.Enqueue(server.delivery()); err != nil {
+		t.Fatal(err)
+	}
+	drainOutbox(t, o)
+	if err := o.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the failed attempt is kept over the restart
+	o, err = NewOutbox(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := pendingDeliveries(t, o)
+	if len(pending) != 1 {
+		t.Fatalf("got %d pending deliveries after the restart, want 1", len(pending))
+	}
+	if d := pending[0]; d.Attempts != 1 || d.Title != "title" || d.Target.Webhook == nil || d.Target.Webhook.URL != server.URL {
+		t.Errorf("got delivery %+v after the restart, want the one that failed once", d)
+	}
+
+	var delivered []*Delivery
+	o.OnDelivered(func(d *Delivery) { delivered = append(delivered, d) })
+	server.status.Store(http.StatusOK)
+	time.Sleep(10 * time.Millisecond) // the backoff
+	drainOutbox(t, o)
+
+	if len(delivered) != 1 || delivered[0].ID != pending[0].ID {
+		t.Errorf("got %d deliveries, want the pending one", len(delivered))
+	}
+	files, err := os.ReadDir(filepath.Join(dir, "pending"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Errorf("got %d pending files after the delivery, want 0", len(files))
+	}
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+const redacted = "<redacted>"
+
+// secretKeys are the config keys whose values are hidden in the config dump.
+var secretKeys = map[string]func(string) string{
+	"shoutrrrURL": redactURL,
+	"postgresURL": redactURL,
+	"url":         redactURL,
+	"secret":      func(string) string { return redacted },
+}
+
+// interpolated values shorter than this are too likely to be ordinary words
+const minSecretLength = 4
+
+// secretValues holds the secrets of any configuration loaded, including
+// previous ones, whose deliveries may still be retried: interpolated values
+// and the values of secret options.
+var secretValues = struct {
+	sync.RWMutex
+	values   map[string]bool
+	replacer *strings.Replacer
+}{values: map[string]bool{}}
+
+func registerSecrets(values []string) {
+	secretValues.Lock()
+	defer secretValues.Unlock()
+	added := false
+	for _, value := range values {
+		if len(value) >= minSecretLength && !secretValues.values[value] {
+			secretValues.values[value] = true
+			added = true
+		}
+	}
+	if !added {
+		return
+	}
+
+	// longer values first, in case one contains another
+	sorted := make([]string, 0, len(secretValues.values))
+	for value := range secretValues.values {
+		sorted = append(sorted, value)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var pairs []string
+	for _, value := range sorted {
+		pairs = append(pairs, value, redacted)
+	}
+	secretValues.replacer = strings.NewReplacer(pairs...)
+}
+
+// collectSecrets returns the values of the secret options in the document,
+// e.g. notifier URLs written in plain text, and the parts of them that may
+// show up on their own in errors.
+func collectSecrets(node *yaml.Node) []string {
+	var secrets []string
+	var walk func(node *yaml.Node, key string)
+	walk = func(node *yaml.Node, key string) {
+		if node.Kind == yaml.ScalarNode {
+			if key != "" && node.Value != "" {
+				secrets = append(secrets, secretParts(key, node.Value)...)
+			}
+			return
+		}
+		for i, child := range node.Content {
+			switch {
+			case node.Kind != yaml.MappingNode:
+				walk(child, key)
+			case i%2 == 1 && key == "":
+				if k := node.Content[i-1].Value; secretKeys[k] != nil || k == "headers" || k == "apiKeys" {
+					walk(child, k)
+				} else {
+					walk(child, "")
+				}
+			case i%2 == 1:
+				walk(child, key)
+			}
+		}
+	}
+	walk(node, "")
+	return secrets
+}
+
+// secretParts splits URLs into the user info, path and query values, which
+// notification services often print without the rest of the URL. The path of
+// a connection string is the database name, which isn't secret.
+func secretParts(key string, value string) []string {
+	parts := []string{value}
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" {
+		return parts
+	}
+	if u.User != nil {
+		parts = append(parts, u.User.Username())
+		if password, ok := u.User.Password(); ok {
+			parts = append(parts, password)
+		}
+	}
+	if path := strings.Trim(u.Path, "/"); path != "" && key != "postgresURL" {
+		parts = append(parts, path)
+	}
+	for _, values := range u.Query() {
+		parts = append(parts, values...)
+	}
+	return parts
+}
+
+// redactSecrets replaces interpolated secrets in s, e.g. a log line.
+func redactSecrets(s string) string {
+	secretValues.RLock()
+	defer secretValues.RUnlock()
+	if secretValues.replacer == nil {
+		return s
+	}
+	return secretValues.replacer.Replace(s)
+}
+
+func redactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if message := redactSecrets(err.Error()); message != err.Error() {
+		return errors.New(message)
+	}
+	return err
+}
+
+// logOutput is where the application logs to. Secrets are redacted in every
+// line, e.g. URLs in errors of deliveries or connection strings in errors
+// of stores.
+var logOutput io.Writer = redactingWriter{os.Stdout}
+
+func logln(a ...any) {
+	fmt.Fprintln(logOutput, a...)
+}
+
+func logf(format string, a ...any) {
+	fmt.Fprintf(logOutput, format, a...)
+}
+
+// redactingWriter redacts secrets in everything written through it, it is
+// used for the output of the log package, which libraries log to.
+type redactingWriter struct {
+	w io.Writer
+}
+
+func (r redactingWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(r.w, redactSecrets(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// redactURL keeps the scheme, and the host of HTTP URLs, since service
+// tokens are part of the user info, path or query.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" {
+		return redacted
+	}
+	if u.Scheme == "http" || u.Scheme == "https" {
+		return u.Scheme + "://" + u.Host + "/" + redacted
+	}
+	return u.Scheme + "://" + redacted
+}
+
+// RedactedYAML returns the effective configuration as YAML, with secrets,
+// interpolated values, API keys and the values of webhook headers hidden.
+// Unset options are left out.
+func (c *Config) RedactedYAML() ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(c); err != nil {
+		return nil, err
+	}
+	redactNode(&node)
+	redactSecretsNode(&node)
+	pruneNode(&node)
+
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&node); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), encoder.Close()
+}
+
+func redactNode(node *yaml.Node) {
+	if node.Kind != yaml.MappingNode {
+		for _, child := range node.Content {
+			redactNode(child)
+		}
+		return
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i].Value, node.Content[i+1]
+		switch {
+		case key == "headers" && value.Kind == yaml.MappingNode:
+			for j := 1; j < len(value.Content); j += 2 {
+				value.Content[j].Value = redacted
+			}
+		case key == "apiKeys" && value.Kind == yaml.SequenceNode:
+			for _, item := range value.Content {
+				item.Value = redacted
+			}
+		case secretKeys[key] != nil && value.Kind == yaml.ScalarNode:
+			if value.Value != "" {
+				value.Value = secretKeys[key](value.Value)
+			}
+		default:
+			redactNode(value)
+		}
+	}
+}
+
+func redactSecretsNode(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode {
+		node.Value = redactSecrets(node.Value)
+	}
+	for _, child := range node.Content {
+		redactSecretsNode(child)
+	}
+}
+
+// pruneNode removes null, empty string and empty collection values.
+func pruneNode(node *yaml.Node) {
+	for _, child := range node.Content {
+		pruneNode(child)
+	}
+	if node.Kind != yaml.MappingNode {
+		return
+	}
+	content := node.Content[:0]
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if !emptyNode(node.Content[i+1]) {
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+	}
+	node.Content = content
+}
+
+func emptyNode(node *yaml.Node) bool {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		return node.Tag == "!!null" || (node.Tag == "!!str" && node.Value == "")
+	case yaml.MappingNode, yaml.SequenceNode:
+		return len(node.Content) == 0
+	}
+	return false
+}
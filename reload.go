@@ -0,0 +1,298 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"gopkg.in/yaml.v3"
+)
+
+// what triggered a reload, used as metric label
+const (
+	reloadFile   = "file"
+	reloadSignal = "signal"
+	reloadAPI    = "api"
+)
+
+// editors and config management write files in several steps
+const reloadDebounce = 500 * time.Millisecond
+
+// sections that are only read at startup
+var restartSections = map[string]bool{"prometheus": true, "storage": true, "api": true}
+
+// Reloader loads the configuration again when the file or the files it
+// includes change, on SIGHUP and when watchers are changed through the API.
+// An invalid configuration is rejected and the previous one is kept.
+type Reloader struct {
+	path   string
+	store  Store
+	status *Status
+	config atomic.Pointer[Config]
+	mutex  sync.Mutex // serializes reloads
+}
+
+func NewReloader(path string, store Store, status *Status, config *Config) *Reloader {
+	r := &Reloader{path: path, store: store, status: status}
+	r.config.Store(config)
+	status.SetConfig(config)
+	return r
+}
+
+// Config returns the current configuration.
+func (r *Reloader) Config() *Config {
+	return r.config.Load()
+}
+
+// Run reloads the configuration when asked to until the context is done.
+func (r *Reloader) Run(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// the directory is watched, since files are often replaced rather than
+	// written, e.g. by editors or when a Kubernetes ConfigMap is updated
+	var events chan fsnotify.Event
+	var errs chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		defer watcher.Close()
+		err = watcher.Add(filepath.Dir(r.path))
+		events, errs = watcher.Events, watcher.Errors
+	}
+	if err != nil {
+		logln("config: failed to watch for changes, reload with SIGHUP instead:", err.Error())
+		watcher = nil
+	}
+	r.watchIncludes(watcher)
+
+	var debounce <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			r.Reload(reloadSignal)
+		case event := <-events:
+			if r.concerns(event) {
+				debounce = time.After(reloadDebounce)
+			}
+		case err := <-errs:
+			logln("config: failed to watch for changes:", err.Error())
+		case <-debounce:
+			debounce = nil
+			r.Reload(reloadFile)
+		}
+		// watchers.d or included directories may have been added
+		r.watchIncludes(watcher)
+	}
+}
+
+// watchIncludes watches watchers.d and the directories of include patterns.
+// Directories that contain wildcards themselves aren't watched.
+func (r *Reloader) watchIncludes(watcher *fsnotify.Watcher) {
+	if watcher == nil {
+		return
+	}
+	dir := filepath.Dir(r.path)
+	dirs := []string{filepath.Join(dir, watchersDir)}
+	for _, pattern := range r.Config().Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		dirs = append(dirs, filepath.Dir(pattern))
+	}
+
+	watched := map[string]bool{}
+	for _, path := range watcher.WatchList() {
+		watched[path] = true
+	}
+	for _, d := range dirs {
+		if watched[d] || strings.ContainsAny(d, `*?[\`) {
+			continue
+		}
+		if info, err := os.Stat(d); err != nil || !info.IsDir() {
+			continue
+		}
+		if err := watcher.Add(d); err != nil {
+			logf("config: failed to watch %s for changes: %s\n", d, err.Error())
+		}
+		watched[d] = true
+	}
+}
+
+func (r *Reloader) concerns(event fsnotify.Event) bool {
+	if event.Op == fsnotify.Chmod {
+		return false
+	}
+	name := filepath.Base(event.Name)
+	// ..data is the symlink swapped on ConfigMap updates
+	if name == filepath.Base(r.path) || name == "..data" || name == watchersDir {
+		return true
+	}
+	ext := filepath.Ext(name)
+	return ext == ".yml" || ext == ".yaml"
+}
+
+// Reload loads the configuration right away and returns why it was
+// rejected, if it was.
+func (r *Reloader) Reload(trigger string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	config, err := LoadConfigWithStore(r.path, r.store, nil)
+	if err != nil {
+		prometheusConfigReloads.WithLabelValues(trigger, "rejected").Inc()
+		logf("config: rejected reload (%s), keeping the previous config: %s\n", trigger, err.Error())
+		return err
+	}
+
+	diff := diffConfigs(r.Config(), config)
+	if diff.empty() {
+		prometheusConfigReloads.WithLabelValues(trigger, "unchanged").Inc()
+		logf("config: reloaded (%s), nothing changed\n", trigger)
+		return nil
+	}
+	r.config.Store(config)
+	r.status.SetConfig(config)
+	prometheusConfigReloads.WithLabelValues(trigger, "applied").Inc()
+	prometheusConfigLastReload.SetToCurrentTime()
+	logf("config: reloaded (%s): %s\n", trigger, diff.String())
+	return nil
+}
+
+// configDiff describes what a reload changed.
+type configDiff struct {
+	added, removed, changed []string // watchers
+	logsAdded, logsRemoved  []string
+	settings                []string // other top-level sections
+}
+
+func (d *configDiff) empty() bool {
+	return len(d.added)+len(d.removed)+len(d.changed)+len(d.logsAdded)+len(d.logsRemoved)+len(d.settings) == 0
+}
+
+func (d *configDiff) String() string {
+	var parts []string
+	add := func(what string, items []string) {
+		if len(items) > 0 {
+			parts = append(parts, what+" "+strings.Join(items, ", "))
+		}
+	}
+	add("watchers added:", d.added)
+	add("watchers removed:", d.removed)
+	add("watchers changed:", d.changed)
+	add("logs added:", d.logsAdded)
+	add("logs removed:", d.logsRemoved)
+	add("settings changed:", d.settings)
+	return strings.Join(parts, "; ")
+}
+
+func diffConfigs(old, new *Config) *configDiff {
+	d := &configDiff{}
+
+	oldWatchers, newWatchers := watcherVersions(old), watcherVersions(new)
+	d.added, d.removed, d.changed = diffVersions(oldWatchers, newWatchers)
+
+	d.logsAdded, d.logsRemoved, _ = diffVersions(logVersions(old), logVersions(new))
+
+	oldSections, newSections := sectionVersions(old), sectionVersions(new)
+	added, removed, changed := diffVersions(oldSections, newSections)
+	for _, section := range append(append(added, removed...), changed...) {
+		if restartSections[section] {
+			section += " (applied after a restart)"
+		}
+		d.settings = append(d.settings, section)
+	}
+	sort.Strings(d.settings)
+	return d
+}
+
+// diffVersions compares two sets of named versions.
+func diffVersions(old, new map[string][]byte) (added, removed, changed []string) {
+	for name, version := range new {
+		previous, ok := old[name]
+		switch {
+		case !ok:
+			added = append(added, name)
+		case !bytes.Equal(previous, version):
+			changed = append(changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := new[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed
+}
+
+// watcherVersions names the watchers by label rather than by index, so that
+// inserting a watcher doesn't change all following ones.
+func watcherVersions(c *Config) map[string][]byte {
+	versions := map[string][]byte{}
+	for i := range c.Watchers {
+		w := &c.Watchers[i]
+		name := fmt.Sprintf("%q", watcherLabel(w))
+		if w.Tenant != "" {
+			name = w.Tenant + "/" + name
+		}
+		for n, base := 2, name; ; n++ {
+			if _, ok := versions[name]; !ok {
+				break
+			}
+			name = fmt.Sprintf("%s#%d", base, n)
+		}
+		version, err := encodeWatcher(w)
+		if err != nil {
+			version = []byte(err.Error())
+		}
+		versions[name] = version
+	}
+	return versions
+}
+
+func logVersions(c *Config) map[string][]byte {
+	versions := map[string][]byte{}
+	if c.LogCollection.GoogleLogListURL != "" {
+		versions["log list "+c.LogCollection.GoogleLogListURL] = []byte{}
+	}
+	for _, url := range c.LogCollection.LogsURLs {
+		versions[url] = []byte{}
+	}
+	return versions
+}
+
+// sectionVersions returns the top-level sections besides the watchers and logs.
+func sectionVersions(c *Config) map[string][]byte {
+	copied := *c
+	copied.Watchers = nil
+	copied.LogCollection.GoogleLogListURL, copied.LogCollection.LogsURLs = "", nil
+
+	versions := map[string][]byte{}
+	var node yaml.Node
+	if err := node.Encode(&copied); err != nil {
+		return versions
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		version, err := yaml.Marshal(node.Content[i+1])
+		if err != nil {
+			continue
+		}
+		versions[node.Content[i].Value] = version
+	}
+	return versions
+}
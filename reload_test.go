@@ -0,0 +1,134 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	const logs = `
+logCollection:
+  logsURLs: [https://ct.example.com/log/]
+`
+	const watchers = `
+watchers:
+  - name: shop
+    glob: "*.shop.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+  - glob: "*.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+`
+	const base = logs + watchers
+
+	tests := []struct {
+		name string
+		new  string
+		want configDiff
+	}{
+		{
+			name: "unchanged",
+			new:  base,
+		},
+		{
+			name: "watcher inserted before the others",
+			new: logs + `
+watchers:
+  - glob: "*.example.org"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+  - name: shop
+    glob: "*.shop.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+  - glob: "*.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+`,
+			want: configDiff{added: []string{`"*.example.org"`}},
+		},
+		{
+			name: "watcher removed",
+			new: logs + `
+watchers:
+  - glob: "*.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+`,
+			want: configDiff{removed: []string{`"shop"`}},
+		},
+		{
+			name: "watcher changed under the same name",
+			new: logs + `
+watchers:
+  - name: shop
+    glob: "*.store.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+  - glob: "*.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/b"}]
+`,
+			want: configDiff{changed: []string{`"*.example.com"`, `"shop"`}},
+		},
+		{
+			name: "second watcher with the same name",
+			new: base + `
+  - name: shop
+    glob: "*.store.example.com"
+    notifiers: [{shoutrrrURL: "generic://hooks.example.com/a"}]
+`,
+			want: configDiff{added: []string{`"shop"#2`}},
+		},
+		{
+			name: "watcher of a tenant",
+			new: base + `
+tenants:
+  - name: red
+    watchers:
+      - glob: "*.example.com"
+        notifiers: [{shoutrrrURL: "generic://hooks.example.com/red"}]
+`,
+			want: configDiff{added: []string{`red/"*.example.com"`}, settings: []string{"tenants"}},
+		},
+		{
+			name: "log added",
+			new: `
+logCollection:
+  logsURLs: [https://ct.example.com/log/, https://ct.example.net/log/]
+` + watchers,
+			want: configDiff{logsAdded: []string{"https://ct.example.net/log/"}},
+		},
+		{
+			name: "settings applied by a reload",
+			new: base + `
+dispatch:
+  workers: 8
+`,
+			want: configDiff{settings: []string{"dispatch"}},
+		},
+		{
+			name: "settings applied after a restart",
+			new: base + `
+prometheus:
+  enabled: true
+api:
+  apiKeys: [admin-test-key]
+`,
+			want: configDiff{settings: []string{"api (applied after a restart)", "prometheus (applied after a restart)"}},
+		},
+	}
+
+	old, err := LoadConfig([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			new, err := LoadConfig([]byte(test.new))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := diffConfigs(old, new)
+			if !reflect.DeepEqual(*got, test.want) {
+				t.Errorf("diffConfigs() = %s, want %s", got, &test.want)
+			}
+			if got.empty() != test.want.empty() {
+				t.Errorf("empty() = %t, want %t", got.empty(), test.want.empty())
+			}
+		})
+	}
+}
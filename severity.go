@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+type Severity string
+
+const (
+	severityInfo     Severity = "info"
+	severityWarning  Severity = "warning"
+	severityCritical Severity = "critical"
+)
+
+func (s Severity) rank() int {
+	switch s {
+	case severityInfo:
+		return 0
+	case severityCritical:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func parseSeverity(path string, s string) (Severity, error) {
+	switch Severity(strings.ToLower(strings.TrimSpace(s))) {
+	case severityInfo:
+		return severityInfo, nil
+	case severityWarning:
+		return severityWarning, nil
+	case severityCritical:
+		return severityCritical, nil
+	default:
+		return "", fmt.Errorf("%s: unknown severity %q (expected info, warning or critical)", path, s)
+	}
+}
+
+// SeverityEnv is what severity rules are evaluated against: the certificate
+// fields of `when` expressions plus what is known about the match.
+type SeverityEnv struct {
+	CertificateEnv
+
+	MatchedNames       []string `expr:"matchedNames"`
+	UnauthorizedIssuer bool     `expr:"unauthorizedIssuer"`
+	CAAViolation       bool     `expr:"caaViolation"`
+}
+
+type SeverityRuleConfig struct {
+	When     string `yaml:"when"`
+	Severity string `yaml:"severity"`
+
+	when     *vm.Program `yaml:"-"`
+	severity Severity    `yaml:"-"`
+}
+
+// compileSeverity parses the watcher's severity and its rules.
+func (w *WatcherConfig) compileSeverity(path string) error {
+	w.severity = severityWarning
+	if strings.TrimSpace(w.Severity) != "" {
+		severity, err := parseSeverity(path+".severity", w.Severity)
+		if err != nil {
+			return err
+		}
+		w.severity = severity
+	}
+
+	for i := range w.SeverityRules {
+		rule := &w.SeverityRules[i]
+		rulePath := fmt.Sprintf("%s.severityRules[%d]", path, i)
+		severity, err := parseSeverity(rulePath+".severity", rule.Severity)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(rule.When) == "" {
+			return fmt.Errorf("%s.when: expression is empty", rulePath)
+		}
+		program, err := compileExpression(rulePath+".when", rule.When, SeverityEnv{})
+		if err != nil {
+			return err
+		}
+		rule.severity, rule.when = severity, program
+	}
+
+	if w.Digest != nil {
+		w.Digest.bypass = severityCritical
+		if strings.TrimSpace(w.Digest.BypassSeverity) != "" {
+			severity, err := parseSeverity(path+".digest.bypassSeverity", w.Digest.BypassSeverity)
+			if err != nil {
+				return err
+			}
+			w.Digest.bypass = severity
+		}
+	}
+	return nil
+}
+
+// SeverityOf returns the highest of the watcher's severity and the severities
+// of its matching rules. Unauthorized issuers and CAA violations are always
+// critical.
+func (w *WatcherConfig) SeverityOf(data *TemplateData) Severity {
+	severity := w.severity
+	if severity == "" {
+		severity = severityWarning
+	}
+	if data.UnauthorizedIssuer || data.CAAViolation {
+		return severityCritical
+	}
+	if len(w.SeverityRules) == 0 {
+		return severity
+	}
+
+	env := &SeverityEnv{
+		CertificateEnv:     data.Cert,
+		MatchedNames:       data.MatchedNames,
+		UnauthorizedIssuer: data.UnauthorizedIssuer,
+		CAAViolation:       data.CAAViolation,
+	}
+	for i, rule := range w.SeverityRules {
+		if rule.when == nil || rule.severity.rank() <= severity.rank() {
+			continue
+		}
+		ok, err := evalExpression(rule.when, env)
+		if err != nil {
+			logf("%s.severityRules[%d]: failed to evaluate expression: %s\n", w.path(w.index), i, err.Error())
+			continue
+		}
+		if ok {
+			severity = rule.severity
+		}
+	}
+	return severity
+}
+
+// compileSeverities parses the severities a notifier subscribes to.
+func (n *NotifierConfig) compileSeverities(path string) error {
+	n.severities = nil
+	for i, s := range n.Severities {
+		severity, err := parseSeverity(fmt.Sprintf("%s.severities[%d]", path, i), s)
+		if err != nil {
+			return err
+		}
+		if n.severities == nil {
+			n.severities = map[Severity]bool{}
+		}
+		n.severities[severity] = true
+	}
+	return nil
+}
+
+// Subscribes reports whether the notifier wants matches of the severity.
+// Notifiers without severities get everything.
+func (n *NotifierConfig) Subscribes(severity Severity) bool {
+	return n.severities == nil || n.severities[severity]
+}
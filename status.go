@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// LogStatus is the progress of one log as shown on the dashboard.
+type LogStatus struct {
+	ID            string     `json:"id"`
+	Description   string     `json:"description"`
+	Operator      string     `json:"operator"`
+	URL           string     `json:"url"`
+	TreeSize      int64      `json:"treeSize"`  // as of the last signed tree head
+	Processed     int64      `json:"processed"` // tree size up to which entries were handled
+	Lag           int64      `json:"lag"`
+	LastSuccess   *time.Time `json:"lastSuccess"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorAt   *time.Time `json:"lastErrorAt,omitempty"`
+	FailureStreak int64      `json:"failureStreak"`
+	BackoffUntil  *time.Time `json:"backoffUntil,omitempty"` // the log isn't polled until then
+}
+
+type WatcherStatus struct {
+	Name    string `json:"name"`
+	Tenant  string `json:"tenant,omitempty"`
+	Matches int64  `json:"matches"` // since the start
+}
+
+// watcherID tells apart watchers with the same label in different tenants.
+type watcherID struct {
+	tenant string
+	name   string
+}
+
+type StatusSnapshot struct {
+	StartedAt time.Time        `json:"startedAt"`
+	Logs      []*LogStatus     `json:"logs"`
+	Watchers  []*WatcherStatus `json:"watchers"`
+}
+
+// Status collects what the dashboard shows about the running process: the
+// progress of the logs, the matches per watcher and the effective config.
+type Status struct {
+	mutex     sync.Mutex
+	startedAt time.Time
+	logs      map[string]*LogStatus
+	hits      map[watcherID]int64
+	config    *Config
+}
+
+func NewStatus() *Status {
+	return &Status{startedAt: time.Now(), logs: map[string]*LogStatus{}, hits: map[watcherID]int64{}}
+}
+
+// log returns the status of a log, the mutex must be held.
+func (s *Status) log(log CtLogUpdateLog) *LogStatus {
+	l, ok := s.logs[log.LogID]
+	if !ok {
+		l = &LogStatus{ID: log.LogID}
+		s.logs[log.LogID] = l
+	}
+	l.Description, l.Operator, l.URL = log.Description, log.OperatorName, log.Url
+	return l
+}
+
+// LogObserved records the tree size of a new signed tree head.
+func (s *Status) LogObserved(log CtLogUpdateLog, treeSize int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.log(log).TreeSize = treeSize
+}
+
+// LogProcessed records that the entries up to the tree size were handled.
+func (s *Status) LogProcessed(log CtLogUpdateLog, treeSize int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	l := s.log(log)
+	l.Processed, l.LastSuccess = treeSize, &now
+	l.FailureStreak, l.BackoffUntil = 0, nil
+}
+
+func (s *Status) LogFailed(log CtLogUpdateLog, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	now := time.Now()
+	l := s.log(log)
+	l.LastError, l.LastErrorAt = err.Error(), &now
+}
+
+// LogBackoff records that the log isn't polled until the given time after
+// failing streak times in a row.
+func (s *Status) LogBackoff(log CtLogUpdateLog, streak int64, until time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	l := s.log(log)
+	l.FailureStreak, l.BackoffUntil = streak, &until
+}
+
+func (s *Status) WatcherMatched(w *WatcherConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.hits[watcherID{w.Tenant, watcherLabel(w)}]++
+}
+
+func (s *Status) SetConfig(config *Config) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.config = config
+}
+
+func (s *Status) Config() *Config {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.config
+}
+
+// Snapshot returns the current status. If tenant is set, only the watchers
+// of that tenant are included.
+func (s *Status) Snapshot(tenant string) *StatusSnapshot {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	snapshot := &StatusSnapshot{StartedAt: s.startedAt, Logs: []*LogStatus{}, Watchers: []*WatcherStatus{}}
+	for _, l := range s.logs {
+		copied := *l
+		if copied.Processed > 0 && copied.TreeSize > copied.Processed {
+			copied.Lag = copied.TreeSize - copied.Processed
+		}
+		snapshot.Logs = append(snapshot.Logs, &copied)
+	}
+	sort.Slice(snapshot.Logs, func(i, j int) bool {
+		return snapshot.Logs[i].Description < snapshot.Logs[j].Description
+	})
+
+	// watchers of the current config first, including those without matches
+	listed := map[watcherID]bool{}
+	add := func(id watcherID, matches int64) {
+		if listed[id] || (tenant != "" && id.tenant != tenant) {
+			return
+		}
+		listed[id] = true
+		snapshot.Watchers = append(snapshot.Watchers, &WatcherStatus{Name: id.name, Tenant: id.tenant, Matches: matches})
+	}
+	if s.config != nil {
+		for i := range s.config.Watchers {
+			w := &s.config.Watchers[i]
+			id := watcherID{w.Tenant, watcherLabel(w)}
+			add(id, s.hits[id])
+		}
+	}
+	for id, matches := range s.hits {
+		add(id, matches)
+	}
+	return snapshot
+}
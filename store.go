@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type StorageConfig struct {
+	Type        string `yaml:"type"`        // empty (disabled), sqlite or postgres
+	Path        string `yaml:"path"`        // database file of the sqlite store
+	PostgresURL string `yaml:"postgresURL"` // connection string of the postgres store
+}
+
+// MatchRecord is a certificate matched by one watcher.
+type MatchRecord struct {
+	ID                 int64      `json:"id"`
+	Fingerprint        string     `json:"sha256Fingerprint"` // SHA-256 of the certificate, hex encoded
+	SPKISHA256         string     `json:"spkiSha256"`
+	Serial             string     `json:"serial"`
+	CommonName         string     `json:"commonName"`
+	Names              []string   `json:"names"`
+	MatchedNames       []string   `json:"matchedNames"`
+	Issuer             string     `json:"issuer"`
+	IssuerCommonName   string     `json:"issuerCommonName"`
+	IssuerOrganization string     `json:"issuerOrganization"`
+	NotBefore          time.Time  `json:"notBefore"`
+	NotAfter           time.Time  `json:"notAfter"`
+	Precert            bool       `json:"precert"`
+	LogID              string     `json:"logId"`
+	LogDescription     string     `json:"logDescription"`
+	LogURL             string     `json:"logUrl"`
+	LogIndex           int64      `json:"logIndex"`
+	Watcher            string     `json:"watcher"`
+	Tenant             string     `json:"tenant,omitempty"`
+	Severity           Severity   `json:"severity"`
+	Raw                []byte     `json:"-"` // DER encoded certificate
+	SeenAt             time.Time  `json:"seenAt"`
+	NotifiedAt         *time.Time `json:"notifiedAt"`
+}
+
+// MatchFilter selects matches from the history. Empty fields don't filter.
+type MatchFilter struct {
+	Domain  string // a name of the certificate is the domain or a subdomain of it
+	Watcher string
+	Tenant  string
+	Issuer  string // substring of the issuer DN, case insensitive
+	Since   time.Time
+	Until   time.Time
+	Before  int64 // only matches with a lower id, for pagination
+	Limit   int
+}
+
+// NameRecord is an entry of the name inventory.
+type NameRecord struct {
+	Name         string    `json:"name"`
+	BaseDomain   string    `json:"baseDomain"`
+	FirstSeen    time.Time `json:"firstSeen"`
+	LastSeen     time.Time `json:"lastSeen"`
+	Certificates int64     `json:"certificates"`
+}
+
+// StoredWatcher is a watcher managed through the API.
+type StoredWatcher struct {
+	ID        string
+	Config    []byte // the WatcherConfig as YAML
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Store keeps the history of matches and the state that should survive a
+// restart: log checkpoints, which certificates were already handled and the
+// names seen in matched certificates.
+type Store interface {
+	// RecordMatch saves the match and sets its ID. Recording the same
+	// certificate for the same watcher of a tenant again returns the
+	// existing ID.
+	RecordMatch(ctx context.Context, m *MatchRecord) error
+	// MarkNotified records that a notification about the matches was sent.
+	MarkNotified(ctx context.Context, ids []int64, at time.Time) error
+	// MarkSeen records a certificate and reports whether it was seen before.
+	MarkSeen(ctx context.Context, key string) (bool, error)
+	// RecordNames adds the names to the inventory of the tenant.
+	RecordNames(ctx context.Context, tenant string, names []string, at time.Time) error
+	// ListMatches returns matches, newest first.
+	ListMatches(ctx context.Context, filter MatchFilter) ([]*MatchRecord, error)
+	// MatchesByFingerprint returns every match of a certificate.
+	MatchesByFingerprint(ctx context.Context, fingerprint string) ([]*MatchRecord, error)
+	// ListNames returns the names of a registrable domain in the inventory of
+	// the tenant ordered by name, starting after the given name.
+	ListNames(ctx context.Context, tenant string, baseDomain string, after string, limit int) ([]*NameRecord, error)
+	LoadCheckpoint(ctx context.Context) (map[string]int64, error)
+	SaveCheckpoint(ctx context.Context, treeSizes map[string]int64) error
+	// ListWatchers returns the watchers managed through the API, oldest first.
+	ListWatchers(ctx context.Context) ([]*StoredWatcher, error)
+	// SaveWatcher creates or replaces a watcher, keeping its creation time.
+	SaveWatcher(ctx context.Context, w *StoredWatcher) error
+	// DeleteWatcher removes a watcher and reports whether it existed.
+	DeleteWatcher(ctx context.Context, id string) (bool, error)
+	Close() error
+}
+
+func NewStore(c StorageConfig) (Store, error) {
+	switch c.Type {
+	case "":
+		return nil, nil
+	case "sqlite":
+		if c.Path == "" {
+			c.Path = "config/certalert.db"
+		}
+		return newSQLiteStore(c.Path)
+	case "postgres":
+		return newPostgresStore(c.PostgresURL)
+	default:
+		return nil, fmt.Errorf("unknown storage type %q (expected sqlite or postgres)", c.Type)
+	}
+}
+
+// watcherLabel identifies a watcher in the match history.
+func watcherLabel(w *WatcherConfig) string {
+	switch {
+	case w.Name != "":
+		return w.Name
+	case w.Glob != "":
+		return w.Glob
+	case w.RegexpRaw != "":
+		return w.RegexpRaw
+	default:
+		return "certificate"
+	}
+}
+
+func NewMatchRecord(data *TemplateData) *MatchRecord {
+	cert := data.Certificate
+	return &MatchRecord{
+		Fingerprint:        certificateFingerprint(cert),
+		SPKISHA256:         spkiFingerprint(cert),
+		Serial:             data.Cert.Serial,
+		CommonName:         cert.Subject.CommonName,
+		Names:              data.Cert.Names,
+		MatchedNames:       data.MatchedNames,
+		Issuer:             cert.Issuer.String(),
+		IssuerCommonName:   cert.Issuer.CommonName,
+		IssuerOrganization: strings.Join(cert.Issuer.Organization, ", "),
+		NotBefore:          cert.NotBefore,
+		NotAfter:           cert.NotAfter,
+		Precert:            data.Precert,
+		LogID:              data.Log.LogID,
+		LogDescription:     data.Log.Description,
+		LogURL:             data.Log.Url,
+		LogIndex:           data.Index,
+		Watcher:            watcherLabel(data.Watcher),
+		Tenant:             data.Watcher.Tenant,
+		Severity:           data.Severity,
+		Raw:                cert.Raw,
+		SeenAt:             time.Now(),
+	}
+}
+
+// inventoryNames returns the names of a certificate as stored in the
+// inventory: lower case and without the wildcard label.
+func inventoryNames(names []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, name := range names {
+		name = strings.TrimPrefix(strings.ToLower(name), "*.")
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+	return out
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern, with \ as escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
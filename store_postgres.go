@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// postgresStore keeps the match history in the certalert_matches table.
+type postgresStore struct {
+	pool *pgxpool.Pool
+}
+
+func newPostgresStore(connString string) (*postgresStore, error) {
+	if connString == "" {
+		return nil, fmt.Errorf("postgresURL is required for the postgres store")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	if err := migratePostgres(ctx, pool); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("migrate database: %w", err)
+	}
+
+	return &postgresStore{pool: pool}, nil
+}
+
+func (s *postgresStore) RecordMatch(ctx context.Context, m *MatchRecord) error {
+	// the no-op update makes RETURNING yield the id of an existing row
+	return s.pool.QueryRow(ctx, `
+		INSERT INTO certalert_matches (
+			fingerprint, spki_sha256, serial, common_name, names, matched_names,
+			issuer, issuer_common_name, issuer_organization, not_before, not_after, precert,
+			log_id, log_description, log_url, log_index, watcher, tenant, severity, raw, seen_at
+		) VALUES ($1, $2, $3, $4, coalesce($5::text[], '{}'), coalesce($6::text[], '{}'), $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21)
+		ON CONFLICT (fingerprint, tenant, watcher) DO UPDATE SET fingerprint = EXCLUDED.fingerprint
+		RETURNING id`,
+		m.Fingerprint, m.SPKISHA256, m.Serial, m.CommonName, m.Names, m.MatchedNames,
+		m.Issuer, m.IssuerCommonName, m.IssuerOrganization, m.NotBefore, m.NotAfter, m.Precert,
+		m.LogID, m.LogDescription, m.LogURL, m.LogIndex, m.Watcher, m.Tenant, string(m.Severity), m.Raw, m.SeenAt,
+	).Scan(&m.ID)
+}
+
+func (s *postgresStore) MarkNotified(ctx context.Context, ids []int64, at time.Time) error {
+	_, err := s.pool.Exec(ctx, `
+		UPDATE certalert_matches SET notified_at = $2 WHERE id = ANY($1) AND notified_at IS NULL`,
+		ids, at)
+	return err
+}
+
+func (s *postgresStore) MarkSeen(ctx context.Context, key string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `
+		INSERT INTO certalert_seen (key, seen_at) VALUES ($1, now()) ON CONFLICT (key) DO NOTHING`, key)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 0, nil
+}
+
+func (s *postgresStore) RecordNames(ctx context.Context, tenant string, names []string, at time.Time) error {
+	batch := &pgx.Batch{}
+	for _, name := range inventoryNames(names) {
+		batch.Queue(`
+			INSERT INTO certalert_names (tenant, name, base_domain, first_seen, last_seen) VALUES ($1, $2, $3, $4, $4)
+			ON CONFLICT (tenant, name) DO UPDATE SET last_seen = EXCLUDED.last_seen, certificates = certalert_names.certificates + 1`,
+			tenant, name, getBaseDomain(name), at)
+	}
+	return s.pool.SendBatch(ctx, batch).Close()
+}
+
+const postgresMatchColumns = `id, fingerprint, spki_sha256, serial, common_name, names, matched_names,
+	issuer, issuer_common_name, issuer_organization, not_before, not_after, precert,
+	log_id, log_description, log_url, log_index, watcher, tenant, severity, raw, seen_at, notified_at`
+
+func (s *postgresStore) queryMatches(ctx context.Context, query string, args ...any) ([]*MatchRecord, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*MatchRecord
+	for rows.Next() {
+		m := &MatchRecord{}
+		var severity string
+		if err := rows.Scan(&m.ID, &m.Fingerprint, &m.SPKISHA256, &m.Serial, &m.CommonName, &m.Names, &m.MatchedNames,
+			&m.Issuer, &m.IssuerCommonName, &m.IssuerOrganization, &m.NotBefore, &m.NotAfter, &m.Precert,
+			&m.LogID, &m.LogDescription, &m.LogURL, &m.LogIndex, &m.Watcher, &m.Tenant, &severity, &m.Raw, &m.SeenAt, &m.NotifiedAt); err != nil {
+			return nil, err
+		}
+		m.Severity = Severity(severity)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *postgresStore) ListMatches(ctx context.Context, filter MatchFilter) ([]*MatchRecord, error) {
+	var conditions []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.Domain != "" {
+		domain := arg(filter.Domain)
+		conditions = append(conditions, fmt.Sprintf(`EXISTS (SELECT 1 FROM unnest(names) AS n WHERE n = %s OR n LIKE %s)`, domain, arg("%."+escapeLike(filter.Domain))))
+	}
+	if filter.Watcher != "" {
+		conditions = append(conditions, "watcher = "+arg(filter.Watcher))
+	}
+	if filter.Tenant != "" {
+		conditions = append(conditions, "tenant = "+arg(filter.Tenant))
+	}
+	if filter.Issuer != "" {
+		conditions = append(conditions, "issuer ILIKE "+arg("%"+escapeLike(filter.Issuer)+"%"))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "seen_at >= "+arg(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "seen_at < "+arg(filter.Until))
+	}
+	if filter.Before > 0 {
+		conditions = append(conditions, "id < "+arg(filter.Before))
+	}
+
+	query := `SELECT ` + postgresMatchColumns + ` FROM certalert_matches`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT " + arg(filter.Limit)
+	return s.queryMatches(ctx, query, args...)
+}
+
+func (s *postgresStore) MatchesByFingerprint(ctx context.Context, fingerprint string) ([]*MatchRecord, error) {
+	return s.queryMatches(ctx, `SELECT `+postgresMatchColumns+` FROM certalert_matches WHERE fingerprint = $1 ORDER BY id`, fingerprint)
+}
+
+func (s *postgresStore) ListNames(ctx context.Context, tenant string, baseDomain string, after string, limit int) ([]*NameRecord, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT name, base_domain, first_seen, last_seen, certificates FROM certalert_names
+		WHERE tenant = $1 AND base_domain = $2 AND name > $3 ORDER BY name LIMIT $4`,
+		tenant, baseDomain, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*NameRecord
+	for rows.Next() {
+		n := &NameRecord{}
+		if err := rows.Scan(&n.Name, &n.BaseDomain, &n.FirstSeen, &n.LastSeen, &n.Certificates); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+func (s *postgresStore) LoadCheckpoint(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.pool.Query(ctx, `SELECT log_id, tree_size FROM certalert_checkpoints`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	treeSizes := map[string]int64{}
+	for rows.Next() {
+		var logID string
+		var treeSize int64
+		if err := rows.Scan(&logID, &treeSize); err != nil {
+			return nil, err
+		}
+		treeSizes[logID] = treeSize
+	}
+	return treeSizes, rows.Err()
+}
+
+func (s *postgresStore) SaveCheckpoint(ctx context.Context, treeSizes map[string]int64) error {
+	batch := &pgx.Batch{}
+	for logID, treeSize := range treeSizes {
+		batch.Queue(`
+			INSERT INTO certalert_checkpoints (log_id, tree_size, updated_at) VALUES ($1, $2, now())
+			ON CONFLICT (log_id) DO UPDATE SET tree_size = EXCLUDED.tree_size, updated_at = EXCLUDED.updated_at`,
+			logID, treeSize)
+	}
+	return s.pool.SendBatch(ctx, batch).Close()
+}
+
+func (s *postgresStore) ListWatchers(ctx context.Context) ([]*StoredWatcher, error) {
+	rows, err := s.pool.Query(ctx, `
+		SELECT id, config, created_at, updated_at FROM certalert_watchers ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*StoredWatcher
+	for rows.Next() {
+		w := &StoredWatcher{}
+		var config string
+		if err := rows.Scan(&w.ID, &config, &w.CreatedAt, &w.UpdatedAt); err != nil {
+			return nil, err
+		}
+		w.Config = []byte(config)
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+func (s *postgresStore) SaveWatcher(ctx context.Context, w *StoredWatcher) error {
+	return s.pool.QueryRow(ctx, `
+		INSERT INTO certalert_watchers (id, config, created_at, updated_at) VALUES ($1, $2, $3, $3)
+		ON CONFLICT (id) DO UPDATE SET config = EXCLUDED.config, updated_at = EXCLUDED.updated_at
+		RETURNING created_at, updated_at`,
+		w.ID, string(w.Config), time.Now(),
+	).Scan(&w.CreatedAt, &w.UpdatedAt)
+}
+
+func (s *postgresStore) DeleteWatcher(ctx context.Context, id string) (bool, error) {
+	tag, err := s.pool.Exec(ctx, `DELETE FROM certalert_watchers WHERE id = $1`, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
+func (s *postgresStore) Close() error {
+	s.pool.Close()
+	return nil
+}
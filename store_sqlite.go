@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// sqliteStore is the embedded store for single node deployments. It keeps
+// everything in one database file.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func newSQLiteStore(path string) (*sqliteStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	// sqlite allows a single writer, more connections only wait for the lock
+	db.SetMaxOpenConns(1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := migrateSQLite(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate %s: %w", path, err)
+	}
+
+	return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) RecordMatch(ctx context.Context, m *MatchRecord) error {
+	names, err := json.Marshal(nonNil(m.Names))
+	if err != nil {
+		return err
+	}
+	matchedNames, err := json.Marshal(nonNil(m.MatchedNames))
+	if err != nil {
+		return err
+	}
+
+	// the no-op update makes RETURNING yield the id of an existing row
+	return s.db.QueryRowContext(ctx, `
+		INSERT INTO certalert_matches (
+			fingerprint, spki_sha256, serial, common_name, names, matched_names,
+			issuer, issuer_common_name, issuer_organization, not_before, not_after, precert,
+			log_id, log_description, log_url, log_index, watcher, tenant, severity, raw, seen_at
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (fingerprint, tenant, watcher) DO UPDATE SET fingerprint = excluded.fingerprint
+		RETURNING id`,
+		m.Fingerprint, m.SPKISHA256, m.Serial, m.CommonName, string(names), string(matchedNames),
+		m.Issuer, m.IssuerCommonName, m.IssuerOrganization, m.NotBefore.UnixMilli(), m.NotAfter.UnixMilli(), m.Precert,
+		m.LogID, m.LogDescription, m.LogURL, m.LogIndex, m.Watcher, m.Tenant, string(m.Severity), m.Raw, m.SeenAt.UnixMilli(),
+	).Scan(&m.ID)
+}
+
+func (s *sqliteStore) MarkNotified(ctx context.Context, ids []int64, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, id := range ids {
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE certalert_matches SET notified_at = ? WHERE id = ? AND notified_at IS NULL`,
+			at.UnixMilli(), id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) MarkSeen(ctx context.Context, key string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO certalert_seen (key, seen_at) VALUES (?, ?) ON CONFLICT (key) DO NOTHING`,
+		key, time.Now().UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	inserted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return inserted == 0, nil
+}
+
+func (s *sqliteStore) RecordNames(ctx context.Context, tenant string, names []string, at time.Time) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, name := range inventoryNames(names) {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO certalert_names (tenant, name, base_domain, first_seen, last_seen) VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT (tenant, name) DO UPDATE SET last_seen = excluded.last_seen, certificates = certificates + 1`,
+			tenant, name, getBaseDomain(name), at.UnixMilli(), at.UnixMilli()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const sqliteMatchColumns = `id, fingerprint, spki_sha256, serial, common_name, names, matched_names,
+	issuer, issuer_common_name, issuer_organization, not_before, not_after, precert,
+	log_id, log_description, log_url, log_index, watcher, tenant, severity, raw, seen_at, notified_at`
+
+func (s *sqliteStore) queryMatches(ctx context.Context, query string, args ...any) ([]*MatchRecord, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*MatchRecord
+	for rows.Next() {
+		m := &MatchRecord{}
+		var names, matchedNames, severity string
+		var notBefore, notAfter, seenAt int64
+		var notifiedAt sql.NullInt64
+		if err := rows.Scan(&m.ID, &m.Fingerprint, &m.SPKISHA256, &m.Serial, &m.CommonName, &names, &matchedNames,
+			&m.Issuer, &m.IssuerCommonName, &m.IssuerOrganization, &notBefore, &notAfter, &m.Precert,
+			&m.LogID, &m.LogDescription, &m.LogURL, &m.LogIndex, &m.Watcher, &m.Tenant, &severity, &m.Raw, &seenAt, &notifiedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(names), &m.Names); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(matchedNames), &m.MatchedNames); err != nil {
+			return nil, err
+		}
+		m.NotBefore, m.NotAfter, m.SeenAt = time.UnixMilli(notBefore), time.UnixMilli(notAfter), time.UnixMilli(seenAt)
+		if notifiedAt.Valid {
+			t := time.UnixMilli(notifiedAt.Int64)
+			m.NotifiedAt = &t
+		}
+		m.Severity = Severity(severity)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) ListMatches(ctx context.Context, filter MatchFilter) ([]*MatchRecord, error) {
+	var conditions []string
+	var args []any
+
+	if filter.Domain != "" {
+		conditions = append(conditions, `EXISTS (SELECT 1 FROM json_each(names) WHERE value = ? OR value LIKE ? ESCAPE '\')`)
+		args = append(args, filter.Domain, "%."+escapeLike(filter.Domain))
+	}
+	if filter.Watcher != "" {
+		conditions = append(conditions, "watcher = ?")
+		args = append(args, filter.Watcher)
+	}
+	if filter.Tenant != "" {
+		conditions = append(conditions, "tenant = ?")
+		args = append(args, filter.Tenant)
+	}
+	if filter.Issuer != "" {
+		// LIKE is case insensitive for ASCII in sqlite
+		conditions = append(conditions, `issuer LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escapeLike(filter.Issuer)+"%")
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "seen_at >= ?")
+		args = append(args, filter.Since.UnixMilli())
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "seen_at < ?")
+		args = append(args, filter.Until.UnixMilli())
+	}
+	if filter.Before > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.Before)
+	}
+
+	query := `SELECT ` + sqliteMatchColumns + ` FROM certalert_matches`
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY id DESC LIMIT ?"
+	args = append(args, filter.Limit)
+	return s.queryMatches(ctx, query, args...)
+}
+
+func (s *sqliteStore) MatchesByFingerprint(ctx context.Context, fingerprint string) ([]*MatchRecord, error) {
+	return s.queryMatches(ctx, `SELECT `+sqliteMatchColumns+` FROM certalert_matches WHERE fingerprint = ? ORDER BY id`, fingerprint)
+}
+
+func (s *sqliteStore) ListNames(ctx context.Context, tenant string, baseDomain string, after string, limit int) ([]*NameRecord, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT name, base_domain, first_seen, last_seen, certificates FROM certalert_names
+		WHERE tenant = ? AND base_domain = ? AND name > ? ORDER BY name LIMIT ?`,
+		tenant, baseDomain, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*NameRecord
+	for rows.Next() {
+		n := &NameRecord{}
+		var firstSeen, lastSeen int64
+		if err := rows.Scan(&n.Name, &n.BaseDomain, &firstSeen, &lastSeen, &n.Certificates); err != nil {
+			return nil, err
+		}
+		n.FirstSeen, n.LastSeen = time.UnixMilli(firstSeen), time.UnixMilli(lastSeen)
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) LoadCheckpoint(ctx context.Context) (map[string]int64, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT log_id, tree_size FROM certalert_checkpoints`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	treeSizes := map[string]int64{}
+	for rows.Next() {
+		var logID string
+		var treeSize int64
+		if err := rows.Scan(&logID, &treeSize); err != nil {
+			return nil, err
+		}
+		treeSizes[logID] = treeSize
+	}
+	return treeSizes, rows.Err()
+}
+
+func (s *sqliteStore) SaveCheckpoint(ctx context.Context, treeSizes map[string]int64) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	now := time.Now().UnixMilli()
+	for logID, treeSize := range treeSizes {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO certalert_checkpoints (log_id, tree_size, updated_at) VALUES (?, ?, ?)
+			ON CONFLICT (log_id) DO UPDATE SET tree_size = excluded.tree_size, updated_at = excluded.updated_at`,
+			logID, treeSize, now); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) ListWatchers(ctx context.Context) ([]*StoredWatcher, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, config, created_at, updated_at FROM certalert_watchers ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []*StoredWatcher
+	for rows.Next() {
+		w := &StoredWatcher{}
+		var config string
+		var createdAt, updatedAt int64
+		if err := rows.Scan(&w.ID, &config, &createdAt, &updatedAt); err != nil {
+			return nil, err
+		}
+		w.Config = []byte(config)
+		w.CreatedAt, w.UpdatedAt = time.UnixMilli(createdAt), time.UnixMilli(updatedAt)
+		out = append(out, w)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) SaveWatcher(ctx context.Context, w *StoredWatcher) error {
+	var createdAt, updatedAt int64
+	now := time.Now().UnixMilli()
+	if err := s.db.QueryRowContext(ctx, `
+		INSERT INTO certalert_watchers (id, config, created_at, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET config = excluded.config, updated_at = excluded.updated_at
+		RETURNING created_at, updated_at`,
+		w.ID, string(w.Config), now, now,
+	).Scan(&createdAt, &updatedAt); err != nil {
+		return err
+	}
+	w.CreatedAt, w.UpdatedAt = time.UnixMilli(createdAt), time.UnixMilli(updatedAt)
+	return nil
+}
+
+func (s *sqliteStore) DeleteWatcher(ctx context.Context, id string) (bool, error) {
+	result, err := s.db.ExecContext(ctx, `DELETE FROM certalert_watchers WHERE id = ?`, id)
+	if err != nil {
+		return false, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return deleted > 0, nil
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+// nonNil makes empty lists encode as [] instead of null.
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
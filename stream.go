@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StreamEvent is a match as sent to stream subscribers.
+type StreamEvent struct {
+	ID      uint64
+	Watcher string
+	Tenant  string
+	Names   []string
+	Data    []byte // MatchPayload as JSON
+}
+
+func (e *StreamEvent) matches(tenant string, watcher string, domain string) bool {
+	if tenant != "" && e.Tenant != tenant {
+		return false
+	}
+	if watcher != "" && e.Watcher != watcher {
+		return false
+	}
+	if domain == "" {
+		return true
+	}
+	for _, name := range e.Names {
+		name = strings.TrimPrefix(strings.ToLower(name), "*.")
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stream fans matches out to subscribers and keeps the most recent ones in a
+// ring buffer, so reconnecting clients can catch up.
+type Stream struct {
+	mutex       sync.Mutex
+	nextID      uint64
+	ring        []*StreamEvent
+	start       int // index of the oldest event in ring
+	size        int
+	subscribers map[chan *StreamEvent]bool
+	closed      bool
+}
+
+func NewStream(bufferSize int) *Stream {
+	if bufferSize <= 0 {
+		bufferSize = 1000
+	}
+	return &Stream{
+		// ids continue to increase across restarts, so a Last-Event-ID from
+		// before a restart never skips events of the new process
+		nextID:      uint64(time.Now().UnixNano()),
+		ring:        make([]*StreamEvent, bufferSize),
+		subscribers: map[chan *StreamEvent]bool{},
+	}
+}
+
+func (s *Stream) Publish(data *TemplateData) {
+	body, err := json.Marshal(NewMatchPayload(data))
+	if err != nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.nextID++
+	event := &StreamEvent{ID: s.nextID, Watcher: watcherLabel(data.Watcher), Tenant: data.Watcher.Tenant, Names: data.Cert.Names, Data: body}
+
+	if s.size < len(s.ring) {
+		s.ring[(s.start+s.size)%len(s.ring)] = event
+		s.size++
+	} else {
+		s.ring[s.start] = event
+		s.start = (s.start + 1) % len(s.ring)
+	}
+
+	for ch := range s.subscribers {
+		select {
+		case ch <- event:
+		default:
+			// a subscriber that can't keep up is disconnected rather than
+			// slowing down dispatching, it can resume with Last-Event-ID
+			delete(s.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// Subscribe returns the buffered events after lastID and a channel receiving
+// new events. The channel is closed if the subscriber falls behind.
+func (s *Stream) Subscribe(lastID uint64) ([]*StreamEvent, chan *StreamEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var backlog []*StreamEvent
+	if lastID > 0 {
+		for i := 0; i < s.size; i++ {
+			event := s.ring[(s.start+i)%len(s.ring)]
+			if event.ID > lastID {
+				backlog = append(backlog, event)
+			}
+		}
+	}
+
+	ch := make(chan *StreamEvent, 64)
+	if s.closed {
+		close(ch)
+		return backlog, ch
+	}
+	s.subscribers[ch] = true
+	return backlog, ch
+}
+
+func (s *Stream) Unsubscribe(ch chan *StreamEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.subscribers[ch] {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
+
+func (s *Stream) Subscribers() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.subscribers)
+}
+
+// Close disconnects all subscribers. Streaming requests never become idle, so
+// the HTTP server would otherwise wait for them until the shutdown timeout.
+func (s *Stream) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.closed = true
+	for ch := range s.subscribers {
+		delete(s.subscribers, ch)
+		close(ch)
+	}
+}
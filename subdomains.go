@@ -5,10 +5,22 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"unicode"
 )
 
-var PublicSuffixList = MustBuildPublicSuffixList()
+// the list is downloaded on first use, so commands that don't need it work offline
+var (
+	publicSuffixOnce sync.Once
+	publicSuffixList []Suffix
+)
+
+func PublicSuffixList() []Suffix {
+	publicSuffixOnce.Do(func() {
+		publicSuffixList = MustBuildPublicSuffixList()
+	})
+	return publicSuffixList
+}
 
 type Suffix struct {
 	Suffix         string
@@ -18,11 +30,12 @@ type Suffix struct {
 func getBaseDomain(input string) string {
 
 	var highestDetailedMatchSeperatorCount int = 0
+	suffixes := PublicSuffixList()
 
-	for i := len(PublicSuffixList) - 1; i > -1; i-- {
+	for i := len(suffixes) - 1; i > -1; i-- {
 
-		if strings.HasSuffix(input, "."+PublicSuffixList[i].Suffix) && PublicSuffixList[i].SeperatorCount > highestDetailedMatchSeperatorCount {
-			highestDetailedMatchSeperatorCount = PublicSuffixList[i].SeperatorCount
+		if strings.HasSuffix(input, "."+suffixes[i].Suffix) && suffixes[i].SeperatorCount > highestDetailedMatchSeperatorCount {
+			highestDetailedMatchSeperatorCount = suffixes[i].SeperatorCount
 		}
 	}
 
@@ -73,7 +86,7 @@ func BuildPublicSuffixList() ([]Suffix, error) {
 
 	}
 
-	fmt.Println("built public suffix list with", len(suffixes), "suffixes")
+	logln("built public suffix list with", len(suffixes), "suffixes")
 
 	return suffixes, nil
 }
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultTitleTemplate = `{{if .UnauthorizedIssuer}}Certalert: Certificate issued by unauthorized CA{{else}}Certalert: Found matching certificate{{end}}`
+
+const defaultMessageTemplate = `{{if .UnauthorizedIssuer}}Severity: {{.Severity}}
+The issuer is not on the allowlist of this watcher.
+{{end}}Issuer: {{.Certificate.Issuer}}
+Subject: {{defang .Certificate.Subject.String}}
+DNS Names: {{defang (join .Certificate.DNSNames ", ")}}
+Log: {{.Log.Description}}
+Valid after: {{.Certificate.NotBefore}}
+Valid until: {{.Certificate.NotAfter}}
+Serial: {{printf "%X" .Certificate.SerialNumber}}
+{{- if .CAA}}
+CAA: {{if .CAAViolation}}VIOLATION, issuance was not permitted by the current CAA records{{else if .CAAInconclusive}}inconclusive, not every name could be checked{{else}}compliant{{end}}
+{{- range .CAA}}
+  {{defang .String}}
+{{- end}}
+{{- end}}`
+
+var defangReplacer = strings.NewReplacer(".", "[.]")
+
+// TemplateConfig holds text/template sources for the notification title and
+// message. Empty fields fall back to the next less specific level: notifier,
+// watcher, global configuration and finally the built-in default.
+type TemplateConfig struct {
+	Title         string `yaml:"title"`
+	Message       string `yaml:"message"`
+	DigestTitle   string `yaml:"digestTitle"`
+	DigestMessage string `yaml:"digestMessage"`
+}
+
+// TemplateData is what notification templates are executed with.
+type TemplateData struct {
+	Certificate        *x509.Certificate
+	Cert               CertificateEnv // the fields documented for `when` expressions
+	MatchedNames       []string       // names that matched the watcher's pattern
+	Watcher            *WatcherConfig
+	Log                CtLogUpdateLog
+	Index              int64
+	Precert            bool
+	CAA                []CAAVerdict
+	CAAViolation       bool
+	CAAInconclusive    bool // a lookup failed or the issuer's CAA identifier is unknown
+	UnauthorizedIssuer bool
+	Severity           Severity
+
+	matchID int64 // id in the match history, if stored
+}
+
+func NewTemplateData(instruction NotifyInstruction, watcher *WatcherConfig, verdicts []CAAVerdict) *TemplateData {
+	entry := instruction.Entry
+
+	var matchedNames []string
+	for _, name := range certificateNames(entry.Certificate) {
+		if watcher.Match(name) {
+			matchedNames = append(matchedNames, name)
+		}
+	}
+
+	data := &TemplateData{
+		Certificate:        entry.Certificate,
+		Cert:               NewCertificateEnv(entry),
+		MatchedNames:       matchedNames,
+		Watcher:            watcher,
+		Log:                entry.Log,
+		Index:              entry.Index,
+		Precert:            entry.Precert,
+		CAA:                verdicts,
+		CAAViolation:       hasCAAViolation(verdicts),
+		CAAInconclusive:    hasCAAInconclusive(verdicts),
+		UnauthorizedIssuer: watcher.AllowedIssuers != nil,
+	}
+	data.Severity = watcher.SeverityOf(data)
+	return data
+}
+
+func certificateFingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// relativeTime formats t relative to now, e.g. "in 3 days" or "5 minutes ago".
+func relativeTime(t time.Time) string {
+	d := time.Until(t)
+	suffix, prefix := "", "in "
+	if d < 0 {
+		d, suffix, prefix = -d, " ago", ""
+	}
+
+	var amount float64
+	var unit string
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		amount, unit = d.Minutes(), "minute"
+	case d < 24*time.Hour:
+		amount, unit = d.Hours(), "hour"
+	default:
+		amount, unit = d.Hours()/24, "day"
+	}
+
+	n := int(math.Floor(amount))
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%s%d %s%s", prefix, n, unit, suffix)
+}
+
+var templateFuncs = template.FuncMap{
+	"defang": defangReplacer.Replace,
+	"join":   strings.Join,
+	"crtsh": func(cert *x509.Certificate) string {
+		return "https://crt.sh/?q=" + certificateFingerprint(cert)
+	},
+	"fingerprint": certificateFingerprint,
+	"relTime":     relativeTime,
+}
+
+// sampleTemplateData is used to execute templates once while loading the
+// configuration, so that references to unknown fields are reported early.
+func sampleTemplateData(watcher *WatcherConfig) *TemplateData {
+	cert := &x509.Certificate{
+		Subject:      pkix.Name{CommonName: "www.example.com", Organization: []string{"Example"}},
+		Issuer:       pkix.Name{CommonName: "Example CA", Organization: []string{"Example CA"}},
+		DNSNames:     []string{"www.example.com", "example.com"},
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+		Raw:          []byte{0},
+	}
+	entry := &CertificateEntry{
+		Certificate: cert,
+		Log:         CtLogUpdateLog{OperatorName: "Example", Description: "Example log", Url: "https://ct.example.com/", LogID: "example"},
+	}
+	data := NewTemplateData(NotifyInstruction{Certificate: cert, Entry: entry}, watcher, []CAAVerdict{{Name: "www.example.com", Status: caaNoRecords}})
+	// every real notification has at least one matched name
+	if len(data.MatchedNames) == 0 {
+		data.MatchedNames = cert.DNSNames
+	}
+	return data
+}
+
+// sampleDigestData is the digest counterpart of sampleTemplateData.
+func sampleDigestData(watcher *WatcherConfig) *DigestData {
+	match := sampleTemplateData(watcher)
+	return &DigestData{
+		Watcher: watcher,
+		Count:   1,
+		Start:   time.Now(),
+		End:     time.Now(),
+		Groups:  []*DigestGroup{{Domain: "example.com", Issuer: "Example CA", Matches: []*TemplateData{match}}},
+	}
+}
+
+func parseTemplate(path string, source string, data any) (*template.Template, error) {
+	// the template is named after its config path, which makes text/template
+	// include the path in its errors
+	t, err := template.New(path).Funcs(templateFuncs).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.Execute(&bytes.Buffer{}, data); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// firstNonEmpty returns the first non-blank template source.
+func firstNonEmpty(sources ...string) string {
+	for _, source := range sources {
+		if strings.TrimSpace(source) != "" {
+			return source
+		}
+	}
+	return ""
+}
+
+func executeTemplate(t *template.Template, data any) (string, error) {
+	var out bytes.Buffer
+	if err := t.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// TenantConfig groups the watchers of one team sharing the instance. Matches
+// are deduplicated per tenant, and API keys of a tenant only give access to
+// its own matches.
+type TenantConfig struct {
+	Name      string           `yaml:"name"`
+	APIKeys   []string         `yaml:"apiKeys"`   // scoped to the matches of the tenant
+	Templates TemplateConfig   `yaml:"templates"` // defaults for the notifiers of the tenant
+	Notifiers []NotifierConfig `yaml:"notifiers"` // named notifiers only the watchers of the tenant can refer to
+	Watchers  []WatcherConfig  `yaml:"watchers"`
+}
+
+var tenantName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// prepareTenants validates the tenants and moves their watchers to the
+// watchers of the config, where the tenant is kept in the watcher.
+func (cfg *Config) prepareTenants() error {
+	var errs []error
+	cfg.tenants = map[string]*TenantConfig{}
+	for i := range cfg.Tenants {
+		t := &cfg.Tenants[i]
+		if !tenantName.MatchString(t.Name) {
+			errs = append(errs, fmt.Errorf("tenant[%d]: name %q must consist of lowercase letters, digits, '-' and '_'", i, t.Name))
+			continue
+		}
+		if cfg.tenants[t.Name] != nil {
+			errs = append(errs, fmt.Errorf("tenant[%d]: name %q is used twice", i, t.Name))
+			continue
+		}
+		cfg.tenants[t.Name] = t
+
+		for j := range t.Watchers {
+			w := t.Watchers[j]
+			if w.Tenant != "" && w.Tenant != t.Name {
+				errs = append(errs, fmt.Errorf("tenant[%s].watcher[%d]: belongs to tenant %q", t.Name, j, w.Tenant))
+				continue
+			}
+			w.Tenant = t.Name
+			w.origin = fmt.Sprintf("tenant[%s].watcher[%d]", t.Name, j)
+			cfg.Watchers = append(cfg.Watchers, w)
+		}
+		t.Watchers = nil
+	}
+	return errors.Join(errs...)
+}
+
+// tenantTemplates returns the template defaults of the watcher's tenant.
+func (cfg *Config) tenantTemplates(w *WatcherConfig) TemplateConfig {
+	if t := cfg.tenants[w.Tenant]; t != nil {
+		return t.Templates
+	}
+	return TemplateConfig{}
+}
+
+// dedupKey identifies a certificate for deduplication within a tenant.
+func dedupKey(tenant string, serial string) string {
+	if tenant == "" {
+		return serial
+	}
+	return tenant + "/" + serial
+}
+
+// firstSeenWatchers returns the watchers of the tenants that see the
+// certificate for the first time. Certificates are deduplicated per tenant,
+// so a match of one tenant doesn't suppress the alert of another. The names
+// of new certificates are added to the tenant's inventory.
+func firstSeenWatchers(store Store, seen *sync.Map, cert *x509.Certificate, watchers []WatcherConfig) []WatcherConfig {
+	serial := cert.SerialNumber.String()
+	duplicates := map[string]bool{}
+	var out []WatcherConfig
+	for _, watcher := range watchers {
+		isDuplicate, checked := duplicates[watcher.Tenant]
+		if !checked {
+			key := dedupKey(watcher.Tenant, serial)
+			if store != nil {
+				// survives restarts, unlike the map
+				marked, err := store.MarkSeen(context.Background(), key)
+				if err != nil {
+					logln("failed to check for duplicate certificate:", err.Error())
+				}
+				isDuplicate = marked
+			} else {
+				_, isDuplicate = seen.LoadOrStore(key, true)
+			}
+			duplicates[watcher.Tenant] = isDuplicate
+
+			if !isDuplicate && store != nil {
+				if err := store.RecordNames(context.Background(), watcher.Tenant, certificateNames(cert), time.Now()); err != nil {
+					logln("failed to record names:", err.Error())
+				}
+			}
+		}
+		if !isDuplicate {
+			out = append(out, watcher)
+		}
+	}
+	return out
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	// the docker image has no zoneinfo, quiet hours need it for their time zone
+	_ "time/tzdata"
+)
+
+type RateLimitConfig struct {
+	Count int      `yaml:"count"`
+	Per   Duration `yaml:"per"`
+}
+
+type QuietHoursConfig struct {
+	Start    string   `yaml:"start"`    // HH:MM
+	End      string   `yaml:"end"`      // HH:MM, before start for windows spanning midnight
+	TimeZone string   `yaml:"timezone"` // IANA name, defaults to UTC
+	Days     []string `yaml:"days"`     // days the window starts on (mon, tue, ...), defaults to every day
+
+	location *time.Location        `yaml:"-"`
+	start    time.Duration         `yaml:"-"`
+	end      time.Duration         `yaml:"-"`
+	days     map[time.Weekday]bool `yaml:"-"`
+}
+
+const (
+	suppressDrop   = "drop"
+	suppressDelay  = "delay"
+	suppressDigest = "digest"
+)
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (q *QuietHoursConfig) compile(path string) error {
+	var err error
+	if q.start, err = parseClock(q.Start); err != nil {
+		return fmt.Errorf("%s.start: %w", path, err)
+	}
+	if q.end, err = parseClock(q.End); err != nil {
+		return fmt.Errorf("%s.end: %w", path, err)
+	}
+	if q.start == q.end {
+		return fmt.Errorf("%s: start and end are the same", path)
+	}
+
+	q.location = time.UTC
+	if q.TimeZone != "" {
+		if q.location, err = time.LoadLocation(q.TimeZone); err != nil {
+			return fmt.Errorf("%s.timezone: %w", path, err)
+		}
+	}
+
+	q.days = nil
+	if len(q.Days) > 0 {
+		q.days = map[time.Weekday]bool{}
+		for _, day := range q.Days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return fmt.Errorf("%s.days: unknown day %q (expected mon, tue, wed, thu, fri, sat or sun)", path, day)
+			}
+			q.days[weekday] = true
+		}
+	}
+	return nil
+}
+
+// clockOn returns the wall clock time on the day, which is not the same as
+// adding it to midnight on days on which daylight saving time starts or ends.
+func clockOn(day time.Time, clock time.Duration) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, day.Location())
+}
+
+// quietUntil returns the end of the window if now is inside of it.
+func (q *QuietHoursConfig) quietUntil(now time.Time) (time.Time, bool) {
+	local := now.In(q.location)
+	// a window spanning midnight may have started yesterday
+	for _, offset := range []int{0, -1} {
+		day := time.Date(local.Year(), local.Month(), local.Day()+offset, 0, 0, 0, 0, q.location)
+		if q.days != nil && !q.days[day.Weekday()] {
+			continue
+		}
+		start := clockOn(day, q.start)
+		end := clockOn(day, q.end)
+		if q.end < q.start {
+			end = clockOn(day.AddDate(0, 0, 1), q.end)
+		}
+		if !now.Before(start) && now.Before(end) {
+			return end, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// validateThrottle checks the rate limit, quiet hours and suppression policy
+// of a notifier.
+func (n *NotifierConfig) validateThrottle(path string) error {
+	if n.RateLimit != nil && (n.RateLimit.Count <= 0 || n.RateLimit.Per.Duration <= 0) {
+		return fmt.Errorf("%s.rateLimit: count and per must be positive", path)
+	}
+	for i := range n.QuietHours {
+		if err := n.QuietHours[i].compile(fmt.Sprintf("%s.quietHours[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	switch n.Suppressed {
+	case "":
+		n.Suppressed = suppressDrop
+	case suppressDrop, suppressDelay, suppressDigest:
+	default:
+		return fmt.Errorf("%s.suppressed: unknown policy %q (expected drop, delay or digest)", path, n.Suppressed)
+	}
+	return nil
+}
+
+func (n *NotifierConfig) throttled() bool {
+	return n.RateLimit != nil || len(n.QuietHours) > 0
+}
+
+type throttleState struct {
+	sent []time.Time // send times (including scheduled ones) within the rate limit window
+
+	notifier *NotifierConfig
+	folded   []*TemplateData // each with the watcher it matched
+	start    time.Time
+	at       time.Time // when the folded matches are sent
+	timer    *time.Timer
+}
+
+// Throttle enforces the rate limits and quiet hours of notifiers in front of
+// the outbox. The state is kept per notification URL, so it survives
+// configuration reloads and is shared by watchers notifying the same target.
+type Throttle struct {
+	outbox *Outbox
+
+	mutex  sync.Mutex
+	states map[string]*throttleState
+}
+
+func NewThrottle(outbox *Outbox) *Throttle {
+	return &Throttle{outbox: outbox, states: map[string]*throttleState{}}
+}
+
+// nextSlot returns the earliest time at or after now that is neither in
+// quiet hours nor exceeds the rate limit, and the reason for any delay.
+func nextSlot(n *NotifierConfig, state *throttleState, now time.Time) (time.Time, string) {
+	if n.RateLimit != nil {
+		cutoff := now.Add(-n.RateLimit.Per.Duration)
+		kept := state.sent[:0]
+		for _, t := range state.sent {
+			if t.After(cutoff) {
+				kept = append(kept, t)
+			}
+		}
+		state.sent = kept
+		sort.Slice(state.sent, func(i, j int) bool { return state.sent[i].Before(state.sent[j]) })
+	}
+
+	at, reason := now, ""
+	// moving past quiet hours can run into the rate limit and vice versa
+	for i := 0; i < 10; i++ {
+		moved := false
+		for j := range n.QuietHours {
+			if until, quiet := n.QuietHours[j].quietUntil(at); quiet {
+				at, reason, moved = until, "quiet_hours", true
+			}
+		}
+		if n.RateLimit != nil && len(state.sent) >= n.RateLimit.Count {
+			// the slot frees up once the count-th latest send leaves the window
+			free := state.sent[len(state.sent)-n.RateLimit.Count].Add(n.RateLimit.Per.Duration)
+			if free.After(at) {
+				at, moved = free, true
+				if reason == "" {
+					reason = "rate_limit"
+				}
+			}
+		}
+		if !moved {
+			break
+		}
+	}
+	return at, reason
+}
+
+func (t *Throttle) state(n *NotifierConfig) *throttleState {
+	key := n.target().key()
+	state, ok := t.states[key]
+	if !ok {
+		state = &throttleState{}
+		t.states[key] = state
+	}
+	return state
+}
+
+// reserve takes the next slot of a throttled notifier and reports when to
+// send, or applies the suppression policy if it may not send right now. Only
+// the bookkeeping happens under the lock, rendering and enqueueing don't.
+func (t *Throttle) reserve(n *NotifierConfig, fold func(state *throttleState, at time.Time)) (time.Time, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	state := t.state(n)
+	now := time.Now()
+	at, reason := nextSlot(n, state, now)
+	if reason == "" {
+		state.sent = append(state.sent, now)
+		return now, true
+	}
+
+	prometheusNotificationsSuppressed.WithLabelValues(n.target().service(), n.tenant, reason, n.Suppressed).Inc()
+
+	switch n.Suppressed {
+	case suppressDelay:
+		state.sent = append(state.sent, at)
+		return at, true
+	case suppressDigest:
+		fold(state, at)
+	}
+	return time.Time{}, false
+}
+
+// Deliver renders a match for the notifier and hands it to the outbox, or
+// applies the notifier's suppression policy if it may not send right now.
+func (t *Throttle) Deliver(n *NotifierConfig, watcher *WatcherConfig, data *TemplateData) error {
+	if !n.throttled() {
+		d, err := n.Delivery(data)
+		if err != nil {
+			return err
+		}
+		return t.outbox.Enqueue(d)
+	}
+
+	at, send := t.reserve(n, func(state *throttleState, at time.Time) {
+		t.fold(n, watcher, state, at, data)
+	})
+	if !send {
+		return nil
+	}
+	d, err := n.Delivery(data)
+	if err != nil {
+		return err
+	}
+	return t.outbox.EnqueueAt(d, at)
+}
+
+// DeliverDigest is Deliver for digests. Digests that can't be sent right
+// away are folded into the notifier's pending digest or delayed.
+func (t *Throttle) DeliverDigest(n *NotifierConfig, watcher *WatcherConfig, digest *DigestData) error {
+	if !n.throttled() {
+		d, err := n.DigestDelivery(digest)
+		if err != nil {
+			return err
+		}
+		return t.outbox.Enqueue(d)
+	}
+
+	at, send := t.reserve(n, func(state *throttleState, at time.Time) {
+		for _, group := range digest.Groups {
+			for _, match := range group.Matches {
+				t.fold(n, watcher, state, at, match)
+			}
+		}
+	})
+	if !send {
+		return nil
+	}
+	return t.enqueueDigest(n, digest, at)
+}
+
+func (t *Throttle) enqueueDigest(n *NotifierConfig, digest *DigestData, at time.Time) error {
+	d, err := n.DigestDelivery(digest)
+	if err != nil {
+		return err
+	}
+	return t.outbox.EnqueueAt(d, at)
+}
+
+// fold adds a match to the notifier's suppressed matches, which are sent as
+// one digest at the next slot.
+func (t *Throttle) fold(n *NotifierConfig, watcher *WatcherConfig, state *throttleState, at time.Time, data *TemplateData) {
+	// several watchers may share the notifier, so each match keeps its own
+	folded := *data
+	w := *watcher
+	folded.Watcher = &w
+	state.notifier = n
+	state.folded = append(state.folded, &folded)
+	if state.timer != nil {
+		return
+	}
+
+	state.start = time.Now()
+	state.at = at
+	key := n.target().key()
+	state.timer = time.AfterFunc(time.Until(at), func() {
+		t.sendFolded(key)
+	})
+}
+
+func (t *Throttle) sendFolded(key string) {
+	at := time.Now()
+	t.mutex.Lock()
+	n, digest := t.takeFoldedLocked(key, at)
+	t.mutex.Unlock()
+
+	if digest == nil {
+		return
+	}
+	if err := t.enqueueDigest(n, digest, at); err != nil {
+		logln("failed to send suppressed notifications:", err.Error())
+	}
+}
+
+// takeFoldedLocked turns the folded matches into a digest sent at the given
+// time. It returns a nil digest if there are none.
+func (t *Throttle) takeFoldedLocked(key string, at time.Time) (*NotifierConfig, *DigestData) {
+	state, ok := t.states[key]
+	if !ok || len(state.folded) == 0 {
+		return nil, nil
+	}
+	if state.timer != nil {
+		state.timer.Stop()
+		state.timer = nil
+	}
+
+	digest := NewDigestData(foldedWatcher(state.folded), state.folded, state.start)
+	state.folded = nil
+	state.sent = append(state.sent, at)
+	return state.notifier, digest
+}
+
+// foldedWatcher returns the watcher of the folded matches if they all come
+// from the same one, otherwise an unnamed watcher of their tenant.
+func foldedWatcher(folded []*TemplateData) *WatcherConfig {
+	watcher := folded[len(folded)-1].Watcher
+	for _, data := range folded {
+		if data.Watcher.key != watcher.key {
+			return &WatcherConfig{Tenant: watcher.Tenant}
+		}
+	}
+	return watcher
+}
+
+// FlushFolded hands all folded matches to the outbox right away, scheduled
+// for when they would have been sent. Used on shutdown, so that they are not
+// lost with a persistent outbox.
+func (t *Throttle) FlushFolded() {
+	type pending struct {
+		notifier *NotifierConfig
+		digest   *DigestData
+		at       time.Time
+	}
+	var flushed []pending
+
+	t.mutex.Lock()
+	for key, state := range t.states {
+		at := state.at
+		if n, digest := t.takeFoldedLocked(key, at); digest != nil {
+			flushed = append(flushed, pending{n, digest, at})
+		}
+	}
+	t.mutex.Unlock()
+
+	for _, p := range flushed {
+		if err := t.enqueueDigest(p.notifier, p.digest, p.at); err != nil {
+			logln("failed to send suppressed notifications:", err.Error())
+		}
+	}
+}
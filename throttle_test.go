@@ -0,0 +1,248 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func quietHours(t *testing.T, q QuietHoursConfig) *QuietHoursConfig {
+	t.Helper()
+	if err := q.compile("quietHours"); err != nil {
+		t.Fatal(err)
+	}
+	return &q
+}
+
+func TestQuietUntil(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(2026, month, day, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name   string
+		config QuietHoursConfig
+		now    time.Time
+		until  time.Time // zero if not quiet
+	}{
+		{
+			name:   "inside",
+			config: QuietHoursConfig{Start: "09:00", End: "17:00"},
+			now:    at(time.UTC, time.June, 10, 12, 0),
+			until:  at(time.UTC, time.June, 10, 17, 0),
+		},
+		{
+			name:   "at the start",
+			config: QuietHoursConfig{Start: "09:00", End: "17:00"},
+			now:    at(time.UTC, time.June, 10, 9, 0),
+			until:  at(time.UTC, time.June, 10, 17, 0),
+		},
+		{
+			name:   "at the end",
+			config: QuietHoursConfig{Start: "09:00", End: "17:00"},
+			now:    at(time.UTC, time.June, 10, 17, 0),
+		},
+		{
+			name:   "spanning midnight, before midnight",
+			config: QuietHoursConfig{Start: "22:00", End: "07:00"},
+			now:    at(time.UTC, time.June, 10, 23, 30),
+			until:  at(time.UTC, time.June, 11, 7, 0),
+		},
+		{
+			name:   "spanning midnight, after midnight",
+			config: QuietHoursConfig{Start: "22:00", End: "07:00"},
+			now:    at(time.UTC, time.June, 11, 3, 0),
+			until:  at(time.UTC, time.June, 11, 7, 0),
+		},
+		{
+			name:   "time zone",
+			config: QuietHoursConfig{Start: "22:00", End: "07:00", TimeZone: "Europe/Berlin"},
+			now:    at(time.UTC, time.June, 10, 21, 0), // 23:00 in Berlin
+			until:  at(berlin, time.June, 11, 7, 0),
+		},
+		{
+			name:   "day not listed",
+			config: QuietHoursConfig{Start: "09:00", End: "17:00", Days: []string{"sat", "sun"}},
+			now:    at(time.UTC, time.June, 10, 12, 0), // a wednesday
+		},
+		{
+			name:   "started on a listed day",
+			config: QuietHoursConfig{Start: "18:00", End: "08:00", Days: []string{"fri"}},
+			now:    at(time.UTC, time.June, 13, 2, 0), // saturday morning
+			until:  at(time.UTC, time.June, 13, 8, 0),
+		},
+		{
+			name:   "daylight saving time starts",
+			config: QuietHoursConfig{Start: "01:00", End: "06:00", TimeZone: "Europe/Berlin"},
+			now:    at(berlin, time.March, 29, 5, 30),
+			until:  at(berlin, time.March, 29, 6, 0),
+		},
+		{
+			name:   "daylight saving time ends",
+			config: QuietHoursConfig{Start: "01:00", End: "06:00", TimeZone: "Europe/Berlin"},
+			now:    at(berlin, time.October, 25, 5, 30),
+			until:  at(berlin, time.October, 25, 6, 0),
+		},
+		{
+			name:   "after the end on the day daylight saving time starts",
+			config: QuietHoursConfig{Start: "01:00", End: "06:00", TimeZone: "Europe/Berlin"},
+			now:    at(berlin, time.March, 29, 6, 30),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := quietHours(t, test.config)
+			until, quiet := q.quietUntil(test.now)
+			if quiet != !test.until.IsZero() || !until.Equal(test.until) {
+				t.Errorf("quietUntil(%s) = %s, %t, want %s", test.now, until, quiet, test.until)
+			}
+		})
+	}
+}
+
+func TestNextSlot(t *testing.T) {
+	now := time.Date(2026, time.June, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		notifier   NotifierConfig
+		sent       []time.Duration // relative to now
+		want       time.Duration
+		wantReason string
+	}{
+		{
+			name:     "not throttled",
+			notifier: NotifierConfig{},
+		},
+		{
+			name:     "below the rate limit",
+			notifier: NotifierConfig{RateLimit: &RateLimitConfig{Count: 2, Per: Duration{time.Hour}}},
+			sent:     []time.Duration{-10 * time.Minute},
+		},
+		{
+			name:       "rate limited",
+			notifier:   NotifierConfig{RateLimit: &RateLimitConfig{Count: 2, Per: Duration{time.Hour}}},
+			sent:       []time.Duration{-10 * time.Minute, -40 * time.Minute},
+			want:       20 * time.Minute,
+			wantReason: "rate_limit",
+		},
+		{
+			name:     "sends outside of the window are forgotten",
+			notifier: NotifierConfig{RateLimit: &RateLimitConfig{Count: 2, Per: Duration{time.Hour}}},
+			sent:     []time.Duration{-10 * time.Minute, -2 * time.Hour},
+		},
+		{
+			name:       "scheduled sends count",
+			notifier:   NotifierConfig{RateLimit: &RateLimitConfig{Count: 1, Per: Duration{time.Hour}}},
+			sent:       []time.Duration{30 * time.Minute},
+			want:       90 * time.Minute,
+			wantReason: "rate_limit",
+		},
+		{
+			name:       "quiet hours",
+			notifier:   NotifierConfig{QuietHours: []QuietHoursConfig{{Start: "11:00", End: "13:00"}}},
+			want:       time.Hour,
+			wantReason: "quiet_hours",
+		},
+		{
+			name: "rate limit after quiet hours",
+			notifier: NotifierConfig{
+				RateLimit:  &RateLimitConfig{Count: 1, Per: Duration{2 * time.Hour}},
+				QuietHours: []QuietHoursConfig{{Start: "11:00", End: "13:00"}},
+			},
+			sent:       []time.Duration{-30 * time.Minute},
+			want:       90 * time.Minute,
+			wantReason: "quiet_hours",
+		},
+		{
+			name: "quiet hours after the rate limit",
+			notifier: NotifierConfig{
+				RateLimit:  &RateLimitConfig{Count: 1, Per: Duration{time.Hour}},
+				QuietHours: []QuietHoursConfig{{Start: "12:30", End: "14:00"}},
+			},
+			sent:       []time.Duration{-15 * time.Minute},
+			want:       2 * time.Hour,
+			wantReason: "quiet_hours",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := test.notifier
+			for i := range n.QuietHours {
+				n.QuietHours[i] = *quietHours(t, n.QuietHours[i])
+			}
+			state := &throttleState{}
+			for _, offset := range test.sent {
+				state.sent = append(state.sent, now.Add(offset))
+			}
+			at, reason := nextSlot(&n, state, now)
+			if !at.Equal(now.Add(test.want)) || reason != test.wantReason {
+				t.Errorf("nextSlot() = %s, %q, want %s, %q", at.Sub(now), reason, test.want, test.wantReason)
+			}
+		})
+	}
+}
+
+func TestFoldedDigestKeepsWatchers(t *testing.T) {
+	// digests group by registrable domain, don't download the list for that
+	publicSuffixOnce.Do(func() {
+		publicSuffixList = []Suffix{{Suffix: "com", SeperatorCount: 1}}
+	})
+
+	config, err := LoadConfig([]byte(`
+logCollection:
+  logsURLs: [https://ct.example.com/log/]
+watchers:
+  - name: first watcher
+    glob: "*.example.com"
+    notifiers:
+      - shoutrrrURL: generic://hooks.example.com/shared
+        rateLimit: {count: 1, per: 1h}
+        suppressed: digest
+  - name: second watcher
+    glob: "*.example.com"
+    notifiers:
+      - shoutrrrURL: generic://hooks.example.com/shared
+        rateLimit: {count: 1, per: 1h}
+        suppressed: digest
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	outbox, err := NewOutbox(OutboxConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	throttle := NewThrottle(outbox)
+
+	first, second := &config.Watchers[0], &config.Watchers[1]
+	for _, watcher := range []*WatcherConfig{first, first, second} {
+		if err := throttle.Deliver(&watcher.Notifiers[0], watcher, sampleTemplateData(watcher)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	throttle.FlushFolded()
+
+	pending, err := outbox.queue.Pending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 2 {
+		t.Fatalf("got %d deliveries, want the first match and a digest", len(pending))
+	}
+	digest := pending[1]
+	for _, name := range []string{"first watcher", "second watcher"} {
+		if !strings.Contains(digest.Message, name) {
+			t.Errorf("digest doesn't name %s:\n%s", name, digest.Message)
+		}
+	}
+	if strings.Contains(digest.Title, "for") {
+		t.Errorf("digest of several watchers is titled with one of them: %s", digest.Title)
+	}
+}
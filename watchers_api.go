@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const maxWatcherSize = 1 << 20
+
+func newWatcherID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// decodeWatcher parses a watcher as sent to the API. JSON is accepted as
+// well, since it is a subset of YAML. Unknown fields are rejected so typos
+// don't silently change what a watcher matches.
+func decodeWatcher(b []byte) (*WatcherConfig, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	var w WatcherConfig
+	if err := decoder.Decode(&w); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("the watcher is empty")
+		}
+		return nil, err
+	}
+	return &w, nil
+}
+
+// encodeWatcher returns the YAML the watcher is stored as.
+func encodeWatcher(w *WatcherConfig) ([]byte, error) {
+	var node yaml.Node
+	if err := node.Encode(w); err != nil {
+		return nil, err
+	}
+	pruneNode(&node)
+	return yaml.Marshal(&node)
+}
+
+// LoadConfigWithStore loads the config file and adds the watchers managed
+// through the API. changes replace stored watchers by ID before validating,
+// a nil config removes the watcher.
+func LoadConfigWithStore(path string, store Store, changes map[string][]byte) (*Config, error) {
+	if store == nil {
+		return loadConfigFiles(path)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stored, err := store.ListWatchers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list stored watchers: %w", err)
+	}
+
+	sources := map[string][]byte{}
+	var ids []string
+	for _, s := range stored {
+		sources[s.ID] = s.Config
+		ids = append(ids, s.ID)
+	}
+	for id, config := range changes {
+		if _, ok := sources[id]; !ok {
+			ids = append(ids, id)
+		}
+		sources[id] = config
+	}
+
+	var extra []WatcherConfig
+	for _, id := range ids {
+		if sources[id] == nil {
+			continue
+		}
+		w, err := decodeWatcher(sources[id])
+		if err != nil {
+			return nil, fmt.Errorf("watcher[%s]: %w", id, err)
+		}
+		w.origin = fmt.Sprintf("watcher[%s]", id)
+		extra = append(extra, *w)
+
+		var node yaml.Node
+		if err := yaml.Unmarshal(sources[id], &node); err == nil {
+			registerSecrets(collectSecrets(&node))
+		}
+	}
+	return loadConfigFiles(path, extra...)
+}
+
+type watcherResponse struct {
+	ID        string    `json:"id"`
+	Watcher   any       `json:"watcher"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func newWatcherResponse(s *StoredWatcher) (*watcherResponse, error) {
+	response := &watcherResponse{ID: s.ID, CreatedAt: s.CreatedAt, UpdatedAt: s.UpdatedAt}
+	// notifiers of the watcher may contain secrets, just like the config file
+	var node yaml.Node
+	if err := yaml.Unmarshal(s.Config, &node); err != nil {
+		return nil, err
+	}
+	redactNode(&node)
+	redactSecretsNode(&node)
+	if err := node.Decode(&response.Watcher); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// managing only lets requests with a key of api.apiKeys pass.
+func (a *API) managing(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !accessOf(r).manage {
+			writeError(w, http.StatusForbidden, "managing watchers requires a key of api.apiKeys")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (a *API) findWatcher(ctx context.Context, id string) (*StoredWatcher, error) {
+	watchers, err := a.store.ListWatchers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range watchers {
+		if w.ID == id {
+			return w, nil
+		}
+	}
+	return nil, nil
+}
+
+func (a *API) listWatchers(w http.ResponseWriter, r *http.Request) {
+	watchers, err := a.store.ListWatchers(r.Context())
+	if err != nil {
+		logln("api: failed to list watchers:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to list watchers")
+		return
+	}
+
+	responses := []*watcherResponse{}
+	for _, stored := range watchers {
+		response, err := newWatcherResponse(stored)
+		if err != nil {
+			logf("api: watcher[%s]: %s\n", stored.ID, err.Error())
+			continue
+		}
+		responses = append(responses, response)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"watchers": responses})
+}
+
+func (a *API) getWatcher(w http.ResponseWriter, r *http.Request) {
+	stored, err := a.findWatcher(r.Context(), r.PathValue("id"))
+	if err != nil {
+		logln("api: failed to get watcher:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to get watcher")
+		return
+	}
+	if stored == nil {
+		writeError(w, http.StatusNotFound, "watcher not found")
+		return
+	}
+	response, err := newWatcherResponse(stored)
+	if err != nil {
+		logln("api: failed to decode watcher:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to decode watcher")
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *API) createWatcher(w http.ResponseWriter, r *http.Request) {
+	a.saveWatcher(w, r, newWatcherID(), true)
+}
+
+func (a *API) updateWatcher(w http.ResponseWriter, r *http.Request) {
+	a.saveWatcher(w, r, r.PathValue("id"), false)
+}
+
+// saveWatcher validates the watcher in the body together with the config
+// file and all other stored watchers, exactly like a reload would, and only
+// stores it if that succeeds. The change is applied by a reload before the
+// response, so a successful response means the watcher is active. Unless
+// the watcher is created, it must exist.
+func (a *API) saveWatcher(w http.ResponseWriter, r *http.Request, id string, create bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxWatcherSize))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "failed to read body")
+		return
+	}
+	watcher, err := decodeWatcher(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	config, err := encodeWatcher(watcher)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	a.watchersMutex.Lock()
+	defer a.watchersMutex.Unlock()
+
+	// checked while holding the lock, so that a concurrent delete can't
+	// make the update create the watcher again
+	var existing *StoredWatcher
+	if !create {
+		existing, err = a.findWatcher(r.Context(), id)
+		if err != nil {
+			logln("api: failed to get watcher:", err.Error())
+			writeError(w, http.StatusInternalServerError, "failed to get watcher")
+			return
+		}
+		if existing == nil {
+			writeError(w, http.StatusNotFound, "watcher not found")
+			return
+		}
+	}
+
+	if _, err := LoadConfigWithStore(a.reloader.path, a.store, map[string][]byte{id: config}); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	stored := &StoredWatcher{ID: id, Config: config}
+	if err := a.store.SaveWatcher(r.Context(), stored); err != nil {
+		logln("api: failed to save watcher:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to save watcher")
+		return
+	}
+	if err := a.reloader.Reload(reloadAPI); err != nil {
+		a.restoreWatcher(r.Context(), id, existing)
+		writeError(w, http.StatusConflict, "the configuration could not be reloaded, the watcher was not saved: "+err.Error())
+		return
+	}
+	response, err := newWatcherResponse(stored)
+	if err != nil {
+		logln("api: failed to decode watcher:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to decode watcher")
+		return
+	}
+	if create {
+		w.Header().Set("location", "/api/v1/watchers/"+id)
+		writeJSON(w, http.StatusCreated, response)
+		return
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *API) deleteWatcher(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+
+	a.watchersMutex.Lock()
+	defer a.watchersMutex.Unlock()
+
+	existing, err := a.findWatcher(r.Context(), id)
+	if err != nil {
+		logln("api: failed to get watcher:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to get watcher")
+		return
+	}
+	if existing == nil {
+		writeError(w, http.StatusNotFound, "watcher not found")
+		return
+	}
+
+	// e.g. the last watcher can't be removed
+	if _, err := LoadConfigWithStore(a.reloader.path, a.store, map[string][]byte{id: nil}); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	deleted, err := a.store.DeleteWatcher(r.Context(), id)
+	if err != nil {
+		logln("api: failed to delete watcher:", err.Error())
+		writeError(w, http.StatusInternalServerError, "failed to delete watcher")
+		return
+	}
+	if !deleted {
+		writeError(w, http.StatusNotFound, "watcher not found")
+		return
+	}
+	if err := a.reloader.Reload(reloadAPI); err != nil {
+		a.restoreWatcher(r.Context(), id, existing)
+		writeError(w, http.StatusConflict, "the configuration could not be reloaded, the watcher was not deleted: "+err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// restoreWatcher undoes a change whose reload was rejected, e.g. because the
+// config file became invalid in the meantime, so that the stored watchers
+// stay those that are active. A nil previous watcher is removed.
+func (a *API) restoreWatcher(ctx context.Context, id string, previous *StoredWatcher) {
+	var err error
+	if previous == nil {
+		_, err = a.store.DeleteWatcher(ctx, id)
+	} else {
+		err = a.store.SaveWatcher(ctx, previous)
+	}
+	if err != nil {
+		logf("api: failed to restore watcher[%s]: %s\n", id, err.Error())
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+const testWatcherBody = `{"glob": "*.example.org", "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`
+
+// rejectingStore makes the reload after the next change of a watcher fail,
+// like a config file that became invalid between validation and reload.
+type rejectingStore struct {
+	Store
+
+	mutex  sync.Mutex
+	armed  bool // the next change makes the reload fail
+	reject bool // the next listing fails
+}
+
+func (s *rejectingStore) rejectNextReload() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.armed = true
+}
+
+func (s *rejectingStore) changed() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.armed {
+		s.armed, s.reject = false, true
+	}
+}
+
+func (s *rejectingStore) ListWatchers(ctx context.Context) ([]*StoredWatcher, error) {
+	s.mutex.Lock()
+	reject := s.reject
+	s.reject = false
+	s.mutex.Unlock()
+	if reject {
+		return nil, errors.New("config became invalid")
+	}
+	return s.Store.ListWatchers(ctx)
+}
+
+func (s *rejectingStore) SaveWatcher(ctx context.Context, w *StoredWatcher) error {
+	if err := s.Store.SaveWatcher(ctx, w); err != nil {
+		return err
+	}
+	s.changed()
+	return nil
+}
+
+func (s *rejectingStore) DeleteWatcher(ctx context.Context, id string) (bool, error) {
+	deleted, err := s.Store.DeleteWatcher(ctx, id)
+	if err == nil {
+		s.changed()
+	}
+	return deleted, err
+}
+
+// hasWatcher reports whether the active config has a watcher with the glob.
+func (a *testAPI) hasWatcher(glob string) bool {
+	for _, w := range a.reloader.Config().Watchers {
+		if w.Glob == glob {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *testAPI) storedWatchers(t *testing.T) []*StoredWatcher {
+	t.Helper()
+	watchers, err := a.store.ListWatchers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return watchers
+}
+
+func (a *testAPI) createTestWatcher(t *testing.T, body string) string {
+	t.Helper()
+	w := a.request(t, "POST", "/api/v1/watchers", "admin-test-key", body)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("creating the watcher: got status %d: %s", w.Code, w.Body.String())
+	}
+	var response watcherResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.Header().Get("location"), "/api/v1/watchers/"+response.ID; got != want {
+		t.Errorf("got location %q, want %q", got, want)
+	}
+	return response.ID
+}
+
+func TestWatcherAPI(t *testing.T) {
+	api := newTestAPI(t, testTenantsConfig)
+
+	id := api.createTestWatcher(t, testWatcherBody)
+	if !api.hasWatcher("*.example.org") {
+		t.Error("the created watcher is not active")
+	}
+	if w := api.request(t, "GET", "/api/v1/watchers/"+id, "admin-test-key", ""); w.Code != http.StatusOK {
+		t.Errorf("getting the watcher: got status %d: %s", w.Code, w.Body.String())
+	}
+
+	updated := `{"glob": "*.example.net", "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`
+	if w := api.request(t, "PUT", "/api/v1/watchers/"+id, "admin-test-key", updated); w.Code != http.StatusOK {
+		t.Fatalf("updating the watcher: got status %d: %s", w.Code, w.Body.String())
+	}
+	if api.hasWatcher("*.example.org") || !api.hasWatcher("*.example.net") {
+		t.Error("the update is not active")
+	}
+
+	if w := api.request(t, "DELETE", "/api/v1/watchers/"+id, "admin-test-key", ""); w.Code != http.StatusNoContent {
+		t.Fatalf("deleting the watcher: got status %d: %s", w.Code, w.Body.String())
+	}
+	if api.hasWatcher("*.example.net") {
+		t.Error("the deleted watcher is still active")
+	}
+	if watchers := api.storedWatchers(t); len(watchers) != 0 {
+		t.Errorf("got %d stored watchers after deleting, want 0", len(watchers))
+	}
+
+	// the watcher is gone now
+	for _, method := range []string{"GET", "PUT", "DELETE"} {
+		if w := api.request(t, method, "/api/v1/watchers/"+id, "admin-test-key", testWatcherBody); w.Code != http.StatusNotFound {
+			t.Errorf("%s of a missing watcher: got status %d, want %d", method, w.Code, http.StatusNotFound)
+		}
+	}
+	if watchers := api.storedWatchers(t); len(watchers) != 0 {
+		t.Errorf("updating a missing watcher stored %d watchers", len(watchers))
+	}
+}
+
+func TestWatcherAPIRejectsInvalidWatchers(t *testing.T) {
+	api := newTestAPI(t, testTenantsConfig)
+
+	tests := map[string]string{
+		"empty":                 ``,
+		"unknown field":         `{"glob": "*.example.org", "notifer": [], "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`,
+		"no notifiers":          `{"glob": "*.example.org"}`,
+		"invalid regexp":        `{"regexp": "(", "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`,
+		"unknown tenant":        `{"glob": "*.example.org", "tenant": "green", "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`,
+		"invalid when":          `{"glob": "*.example.org", "when": "Unknown > 1", "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`,
+		"invalid shoutrrr URL":  `{"glob": "*.example.org", "notifiers": [{"shoutrrrURL": "unknown://hooks.example.com/api"}]}`,
+		"glob and regexp given": `{"glob": "*.example.org", "regexp": "example", "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`,
+	}
+	for name, body := range tests {
+		t.Run(name, func(t *testing.T) {
+			if w := api.request(t, "POST", "/api/v1/watchers", "admin-test-key", body); w.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+			}
+		})
+	}
+
+	// an update is validated the same way
+	id := api.createTestWatcher(t, testWatcherBody)
+	before := api.storedWatchers(t)[0].Config
+	if w := api.request(t, "PUT", "/api/v1/watchers/"+id, "admin-test-key", tests["no notifiers"]); w.Code != http.StatusBadRequest {
+		t.Errorf("updating with an invalid watcher: got status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if watchers := api.storedWatchers(t); len(watchers) != 1 || string(watchers[0].Config) != string(before) {
+		t.Error("invalid watchers were stored")
+	}
+	if !api.hasWatcher("*.example.org") {
+		t.Error("the rejected update changed the active watcher")
+	}
+}
+
+func TestWatcherAPIKeepsLastWatcher(t *testing.T) {
+	var id string
+	api := newTestAPIWithStore(t, `
+logCollection:
+  logsURLs: [https://ct.example.com/log/]
+api:
+  apiKeys: [admin-test-key]
+`, func(store Store) Store {
+		config, err := encodeWatcher(&WatcherConfig{Glob: "*.example.org", Notifiers: []NotifierConfig{{ShoutrrrURL: "generic://hooks.example.com/api"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		id = newWatcherID()
+		if err := store.SaveWatcher(context.Background(), &StoredWatcher{ID: id, Config: config}); err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+
+	if w := api.request(t, "DELETE", "/api/v1/watchers/"+id, "admin-test-key", ""); w.Code != http.StatusConflict {
+		t.Errorf("deleting the last watcher: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if watchers := api.storedWatchers(t); len(watchers) != 1 {
+		t.Errorf("got %d stored watchers, want the last one kept", len(watchers))
+	}
+}
+
+func TestWatcherAPIRollsBackRejectedReloads(t *testing.T) {
+	store := &rejectingStore{}
+	api := newTestAPIWithStore(t, testTenantsConfig, func(s Store) Store {
+		store.Store = s
+		return store
+	})
+
+	store.rejectNextReload()
+	if w := api.request(t, "POST", "/api/v1/watchers", "admin-test-key", testWatcherBody); w.Code != http.StatusConflict {
+		t.Errorf("creating: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if watchers := api.storedWatchers(t); len(watchers) != 0 {
+		t.Errorf("creating: got %d stored watchers, want the new one removed", len(watchers))
+	}
+	if api.hasWatcher("*.example.org") {
+		t.Error("creating: the watcher is active")
+	}
+
+	id := api.createTestWatcher(t, testWatcherBody)
+	before := api.storedWatchers(t)[0].Config
+
+	store.rejectNextReload()
+	updated := `{"glob": "*.example.net", "notifiers": [{"shoutrrrURL": "generic://hooks.example.com/api"}]}`
+	if w := api.request(t, "PUT", "/api/v1/watchers/"+id, "admin-test-key", updated); w.Code != http.StatusConflict {
+		t.Errorf("updating: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if watchers := api.storedWatchers(t); len(watchers) != 1 || string(watchers[0].Config) != string(before) {
+		t.Error("updating: the previous watcher was not restored")
+	}
+	if !api.hasWatcher("*.example.org") || api.hasWatcher("*.example.net") {
+		t.Error("updating: the update is active")
+	}
+
+	store.rejectNextReload()
+	if w := api.request(t, "DELETE", "/api/v1/watchers/"+id, "admin-test-key", ""); w.Code != http.StatusConflict {
+		t.Errorf("deleting: got status %d, want %d", w.Code, http.StatusConflict)
+	}
+	if watchers := api.storedWatchers(t); len(watchers) != 1 || watchers[0].ID != id {
+		t.Error("deleting: the watcher was not restored")
+	}
+	if !api.hasWatcher("*.example.org") {
+		t.Error("deleting: the watcher is not active anymore")
+	}
+}
@@ -0,0 +1,128 @@
+// The dashboard only uses the JSON API, see README.md.
+
+// asked for once the API responds with 401, kept for the next visit
+const keyForm = document.getElementById("key");
+let apiKey = localStorage.getItem("certalert.apiKey") || "";
+
+const api = (path) => fetch(path, {
+  headers: apiKey ? { authorization: `Bearer ${apiKey}` } : {},
+}).then(async (response) => {
+  if (response.status === 401) {
+    keyForm.hidden = false;
+  }
+  if (!response.ok) {
+    const body = await response.json().catch(() => ({}));
+    throw new Error(body.error || response.statusText);
+  }
+  return response;
+});
+
+const cell = (row, text, className) => {
+  const td = row.insertCell();
+  td.textContent = text ?? "";
+  if (className) td.className = className;
+  return td;
+};
+
+const time = (value) => value ? new Date(value).toLocaleString() : "";
+
+const duration = (ms) => {
+  const s = Math.floor(ms / 1000);
+  if (s < 60) return `${s}s`;
+  if (s < 3600) return `${Math.floor(s / 60)}m`;
+  if (s < 86400) return `${Math.floor(s / 3600)}h ${Math.floor((s % 3600) / 60)}m`;
+  return `${Math.floor(s / 86400)}d ${Math.floor((s % 86400) / 3600)}h`;
+};
+
+async function loadStatus() {
+  const status = await api("api/v1/status").then((r) => r.json());
+  document.getElementById("uptime").textContent =
+    `up for ${duration(Date.now() - new Date(status.startedAt))}`;
+
+  const logs = document.querySelector("#logs tbody");
+  logs.replaceChildren();
+  for (const log of status.logs) {
+    const row = logs.insertRow();
+    cell(row, log.description).title = log.url;
+    cell(row, log.operator);
+    cell(row, log.treeSize.toLocaleString(), "number");
+    cell(row, log.lag.toLocaleString(), "number");
+    cell(row, time(log.lastSuccess));
+    const backoff = log.backoffUntil && new Date(log.backoffUntil) > Date.now()
+      ? `until ${time(log.backoffUntil)} (${log.failureStreak} failures)` : "";
+    cell(row, backoff, backoff && "warning");
+    cell(row, log.lastError ? `${time(log.lastErrorAt)}: ${log.lastError}` : "", "error");
+  }
+
+  const watchers = document.querySelector("#watchers tbody");
+  watchers.replaceChildren();
+  for (const watcher of status.watchers) {
+    const row = watchers.insertRow();
+    cell(row, watcher.name);
+    cell(row, watcher.tenant);
+    cell(row, watcher.matches.toLocaleString(), "number");
+  }
+}
+
+let cursor = "";
+
+async function loadMatches(append) {
+  const params = new URLSearchParams(new FormData(document.getElementById("search")));
+  for (const [key, value] of [...params]) {
+    if (!value) params.delete(key);
+  }
+  params.set("limit", "50");
+  if (append && cursor) params.set("cursor", cursor);
+
+  const tbody = document.querySelector("#matches tbody");
+  const error = document.getElementById("matches-error");
+  if (!append) tbody.replaceChildren();
+  try {
+    const page = await api(`api/v1/matches?${params}`).then((r) => r.json());
+    error.hidden = true;
+    for (const match of page.matches) {
+      const row = tbody.insertRow();
+      cell(row, time(match.seenAt));
+      cell(row, match.watcher);
+      cell(row, match.tenant);
+      cell(row, match.severity, match.severity);
+      const names = cell(row, match.names.join(", "));
+      names.title = match.sha256Fingerprint;
+      cell(row, match.issuerCommonName || match.issuer);
+      cell(row, time(match.notifiedAt));
+    }
+    cursor = page.nextCursor || "";
+  } catch (e) {
+    error.textContent = `Matches are not available: ${e.message}`;
+    error.hidden = false;
+    cursor = "";
+  }
+  document.getElementById("more").hidden = !cursor;
+}
+
+async function loadConfig() {
+  document.getElementById("config").textContent =
+    await api("api/v1/config").then((r) => r.text()).catch((e) => e.message);
+}
+
+keyForm.addEventListener("submit", (event) => {
+  event.preventDefault();
+  apiKey = new FormData(keyForm).get("key");
+  localStorage.setItem("certalert.apiKey", apiKey);
+  keyForm.hidden = true;
+  refresh();
+  loadMatches(false);
+  loadConfig();
+});
+
+document.getElementById("search").addEventListener("submit", (event) => {
+  event.preventDefault();
+  loadMatches(false);
+});
+document.getElementById("more").addEventListener("click", () => loadMatches(true));
+
+const refresh = () => loadStatus().catch((e) => console.error(e));
+refresh();
+setInterval(refresh, 10000);
+loadMatches(false);
+loadConfig();
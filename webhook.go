@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// matchPayloadVersion is increased whenever fields of MatchPayload are
+// renamed or removed. Adding fields doesn't change the version.
+const matchPayloadVersion = 1
+
+type WebhookConfig struct {
+	URL     string            `yaml:"url"`
+	Secret  string            `yaml:"secret"` // signs requests with HMAC-SHA256 if set
+	Headers map[string]string `yaml:"headers"`
+	Timeout Duration          `yaml:"timeout"`
+}
+
+type MatchPayloadWatcher struct {
+	Name   string `json:"name,omitempty"`
+	Glob   string `json:"glob,omitempty"`
+	Regexp string `json:"regexp,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+}
+
+type MatchPayloadIssuer struct {
+	DN           string   `json:"dn"`
+	CommonName   string   `json:"commonName"`
+	Organization []string `json:"organization"`
+}
+
+type MatchPayloadCertificate struct {
+	Names             []string           `json:"names"`
+	MatchedNames      []string           `json:"matchedNames"`
+	CommonName        string             `json:"commonName"`
+	Subject           string             `json:"subject"`
+	Issuer            MatchPayloadIssuer `json:"issuer"`
+	Serial            string             `json:"serial"`
+	SHA256Fingerprint string             `json:"sha256Fingerprint"`
+	SPKISHA256        string             `json:"spkiSha256"`
+	NotBefore         time.Time          `json:"notBefore"`
+	NotAfter          time.Time          `json:"notAfter"`
+	Precert           bool               `json:"precert"`
+	PEM               string             `json:"pem"`
+}
+
+type MatchPayloadLog struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Operator    string `json:"operator"`
+	URL         string `json:"url"`
+	Index       int64  `json:"index"`
+}
+
+type MatchPayloadCAA struct {
+	Name    string   `json:"name"`
+	Domain  string   `json:"domain,omitempty"`
+	Status  string   `json:"status"`
+	Records []string `json:"records,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// MatchPayload is the JSON document describing a single match.
+type MatchPayload struct {
+	Version            int                     `json:"version"`
+	Type               string                  `json:"type"`
+	Severity           Severity                `json:"severity"`
+	Title              string                  `json:"title,omitempty"`
+	Message            string                  `json:"message,omitempty"`
+	Watcher            MatchPayloadWatcher     `json:"watcher"`
+	Certificate        MatchPayloadCertificate `json:"certificate"`
+	Log                MatchPayloadLog         `json:"log"`
+	CAA                []MatchPayloadCAA       `json:"caa,omitempty"`
+	UnauthorizedIssuer bool                    `json:"unauthorizedIssuer"`
+}
+
+type DigestPayloadGroup struct {
+	Domain  string          `json:"domain"`
+	Issuer  string          `json:"issuer"`
+	Matches []*MatchPayload `json:"matches"`
+}
+
+// DigestPayload is the JSON document describing a digest of matches.
+type DigestPayload struct {
+	Version int                  `json:"version"`
+	Type    string               `json:"type"`
+	Title   string               `json:"title,omitempty"`
+	Message string               `json:"message,omitempty"`
+	Watcher MatchPayloadWatcher  `json:"watcher"`
+	Count   int                  `json:"count"`
+	Start   time.Time            `json:"start"`
+	End     time.Time            `json:"end"`
+	Groups  []DigestPayloadGroup `json:"groups"`
+}
+
+func NewMatchPayload(data *TemplateData) *MatchPayload {
+	cert := data.Certificate
+
+	payload := &MatchPayload{
+		Version:  matchPayloadVersion,
+		Type:     "match",
+		Severity: data.Severity,
+		Watcher: MatchPayloadWatcher{
+			Name:   data.Watcher.Name,
+			Glob:   data.Watcher.Glob,
+			Regexp: data.Watcher.RegexpRaw,
+			Tenant: data.Watcher.Tenant,
+		},
+		Certificate: MatchPayloadCertificate{
+			Names:        data.Cert.Names,
+			MatchedNames: data.MatchedNames,
+			CommonName:   cert.Subject.CommonName,
+			Subject:      cert.Subject.String(),
+			Issuer: MatchPayloadIssuer{
+				DN:           cert.Issuer.String(),
+				CommonName:   cert.Issuer.CommonName,
+				Organization: cert.Issuer.Organization,
+			},
+			Serial:            data.Cert.Serial,
+			SHA256Fingerprint: certificateFingerprint(cert),
+			SPKISHA256:        spkiFingerprint(cert),
+			NotBefore:         cert.NotBefore,
+			NotAfter:          cert.NotAfter,
+			Precert:           data.Precert,
+			PEM:               string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		},
+		Log: MatchPayloadLog{
+			ID:          data.Log.LogID,
+			Description: data.Log.Description,
+			Operator:    data.Log.OperatorName,
+			URL:         data.Log.Url,
+			Index:       data.Index,
+		},
+		UnauthorizedIssuer: data.UnauthorizedIssuer,
+	}
+
+	for _, verdict := range data.CAA {
+		caa := MatchPayloadCAA{
+			Name:    verdict.Name,
+			Domain:  verdict.Domain,
+			Status:  string(verdict.Status),
+			Records: verdict.Records,
+		}
+		if verdict.Err != nil {
+			caa.Error = verdict.Err.Error()
+		}
+		payload.CAA = append(payload.CAA, caa)
+	}
+
+	return payload
+}
+
+func (w *WebhookConfig) validate(path string) error {
+	if strings.TrimSpace(w.URL) == "" {
+		return fmt.Errorf("%s: url is empty", path)
+	}
+	if !strings.HasPrefix(w.URL, "http://") && !strings.HasPrefix(w.URL, "https://") {
+		return fmt.Errorf("%s: url %q must start with http:// or https://", path, w.URL)
+	}
+	for name := range w.Headers {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("%s.headers: header name is empty", path)
+		}
+	}
+	return nil
+}
+
+// signWebhook returns the hex encoded HMAC-SHA256 of "<timestamp>.<body>".
+func signWebhook(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send POSTs the body as JSON to the webhook.
+func (w *WebhookConfig) Send(body []byte) error {
+	timeout := w.Timeout.Duration
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("user-agent", "github.com/janic0/certalert")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+	if w.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set("x-certalert-timestamp", timestamp)
+		req.Header.Set("x-certalert-signature", "sha256="+signWebhook(w.Secret, timestamp, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s responded with status %d", w.URL, resp.StatusCode)
+	}
+	return nil
+}
+
+// MatchWebhookBody returns the JSON document sent to webhooks for a match.
+func MatchWebhookBody(title string, message string, data *TemplateData) ([]byte, error) {
+	payload := NewMatchPayload(data)
+	payload.Title, payload.Message = title, message
+	return json.Marshal(payload)
+}
+
+// DigestWebhookBody returns the JSON document sent to webhooks for a digest.
+func DigestWebhookBody(title string, message string, digest *DigestData) ([]byte, error) {
+	payload := &DigestPayload{
+		Version: matchPayloadVersion,
+		Type:    "digest",
+		Title:   title,
+		Message: message,
+		Watcher: MatchPayloadWatcher{
+			Name:   digest.Watcher.Name,
+			Glob:   digest.Watcher.Glob,
+			Regexp: digest.Watcher.RegexpRaw,
+			Tenant: digest.Watcher.Tenant,
+		},
+		Count: digest.Count,
+		Start: digest.Start,
+		End:   digest.End,
+	}
+	for _, group := range digest.Groups {
+		payloadGroup := DigestPayloadGroup{Domain: group.Domain, Issuer: group.Issuer}
+		for _, match := range group.Matches {
+			payloadGroup.Matches = append(payloadGroup.Matches, NewMatchPayload(match))
+		}
+		payload.Groups = append(payload.Groups, payloadGroup)
+	}
+	return json.Marshal(payload)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type webhookRequest struct {
+	header http.Header
+	body   []byte
+}
+
+// startWebhookServer records the requests it receives and answers them with
+// the status.
+func startWebhookServer(t *testing.T, status int) (*httptest.Server, chan webhookRequest) {
+	t.Helper()
+	requests := make(chan webhookRequest, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		requests <- webhookRequest{header: r.Header, body: body}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestWebhookSignature(t *testing.T) {
+	server, requests := startWebhookServer(t, http.StatusNoContent)
+	webhook := &WebhookConfig{URL: server.URL, Secret: "webhook-test-secret", Headers: map[string]string{"x-team": "security"}}
+
+	body := []byte(`{"type":"match"}`)
+	if err := webhook.Send(body); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+
+	if string(req.body) != string(body) {
+		t.Errorf("got body %s, want %s", req.body, body)
+	}
+	if got := req.header.Get("content-type"); got != "application/json" {
+		t.Errorf("got content-type %q, want application/json", got)
+	}
+	if got := req.header.Get("x-team"); got != "security" {
+		t.Errorf("got header x-team %q, want security", got)
+	}
+
+	timestamp := req.header.Get("x-certalert-timestamp")
+	sent, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		t.Fatalf("timestamp %q is not in unix seconds: %v", timestamp, err)
+	}
+	if age := time.Since(time.Unix(sent, 0)); age < -time.Second || age > time.Minute {
+		t.Errorf("timestamp is %s old", age)
+	}
+
+	// verified the way a receiver would
+	mac := hmac.New(sha256.New, []byte("webhook-test-secret"))
+	mac.Write([]byte(timestamp + "." + string(body)))
+	want := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	if got := req.header.Get("x-certalert-signature"); !hmac.Equal([]byte(got), []byte(want)) {
+		t.Errorf("got signature %q, want %q", got, want)
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	server, requests := startWebhookServer(t, http.StatusOK)
+	if err := (&WebhookConfig{URL: server.URL}).Send([]byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	req := <-requests
+	if req.header.Get("x-certalert-signature") != "" || req.header.Get("x-certalert-timestamp") != "" {
+		t.Error("unsigned webhook sent a signature")
+	}
+}
+
+func TestWebhookErrorStatus(t *testing.T) {
+	server, requests := startWebhookServer(t, http.StatusInternalServerError)
+	if err := (&WebhookConfig{URL: server.URL}).Send([]byte(`{}`)); err == nil {
+		t.Error("Send() succeeded on status 500")
+	}
+	<-requests
+}
+
+func TestMatchWebhookBody(t *testing.T) {
+	watcher := &WatcherConfig{Name: "example", Glob: "*.example.com", Tenant: "red"}
+	body, err := MatchWebhookBody("New certificate", "www.example.com", sampleTemplateData(watcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decoded generically, so renamed fields are caught
+	var payload map[string]any
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	field := func(path ...string) any {
+		var v any = payload
+		for _, key := range path {
+			object, ok := v.(map[string]any)
+			if !ok {
+				return nil
+			}
+			v = object[key]
+		}
+		return v
+	}
+
+	tests := []struct {
+		path []string
+		want any
+	}{
+		{path: []string{"version"}, want: float64(matchPayloadVersion)},
+		{path: []string{"type"}, want: "match"},
+		{path: []string{"title"}, want: "New certificate"},
+		{path: []string{"message"}, want: "www.example.com"},
+		{path: []string{"watcher", "name"}, want: "example"},
+		{path: []string{"watcher", "glob"}, want: "*.example.com"},
+		{path: []string{"watcher", "tenant"}, want: "red"},
+		{path: []string{"certificate", "commonName"}, want: "www.example.com"},
+		{path: []string{"certificate", "serial"}, want: sampleTemplateData(watcher).Cert.Serial},
+		{path: []string{"certificate", "issuer", "commonName"}, want: "Example CA"},
+		{path: []string{"log", "id"}, want: "example"},
+		{path: []string{"log", "url"}, want: "https://ct.example.com/"},
+		{path: []string{"unauthorizedIssuer"}, want: false},
+	}
+	for _, test := range tests {
+		if got := field(test.path...); got != test.want {
+			t.Errorf("%v = %v, want %v", test.path, got, test.want)
+		}
+	}
+
+	for _, path := range [][]string{{"severity"}, {"certificate", "names"}, {"certificate", "matchedNames"}, {"certificate", "sha256Fingerprint"}, {"certificate", "pem"}, {"caa"}} {
+		if field(path...) == nil {
+			t.Errorf("%v is missing", path)
+		}
+	}
+}